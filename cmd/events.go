@@ -0,0 +1,102 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/wtran29/go-orchestrator/task"
+)
+
+// eventsCmd represents the events command
+var eventsCmd = &cobra.Command{
+	Use:   "events",
+	Short: "List the event history of tasks.",
+	Long: `archon events command.
+
+The events command lists what has happened to tasks, oldest first: state
+changes reported by workers along with the manager's own events, such as a
+task being scheduled to a worker, failing a health check or being restarted.
+Use --since with a duration (e.g. 10m) or an RFC 3339 time to only list
+recent events.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		taskID, _ := cmd.Flags().GetString("task")
+		state, _ := cmd.Flags().GetString("state")
+		since, _ := cmd.Flags().GetString("since")
+
+		q := url.Values{}
+		if taskID != "" {
+			q.Set("task", taskID)
+		}
+		if state != "" {
+			q.Set("state", state)
+		}
+		if since != "" {
+			t, err := parseSince(since)
+			if err != nil {
+				log.Fatal(err)
+			}
+			q.Set("since", t.Format(time.RFC3339))
+		}
+		u := fmt.Sprintf("http://%s/events?%s", manager, q.Encode())
+		resp, err := http.Get(u)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("Error sending request: %v %s", resp.StatusCode, body)
+		}
+
+		var events []*task.TaskEvent
+		err = json.Unmarshal(body, &events)
+		if err != nil {
+			log.Fatal(err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 5, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "TIME\tTASK\tSTATE\tREASON\tMESSAGE\t")
+		for _, te := range events {
+			reason := te.Reason
+			if reason == "" {
+				reason = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t\n", te.Timestamp.Local().Format(time.DateTime), te.Task.ID, te.State, reason, te.Message)
+		}
+		w.Flush()
+	},
+}
+
+// parseSince accepts either a duration before now, e.g. 10m, or an RFC 3339 time
+func parseSince(since string) (time.Time, error) {
+	if d, err := time.ParseDuration(since); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, since)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("--since must be a duration or an RFC 3339 time: %q", since)
+	}
+	return t, nil
+}
+
+func init() {
+	rootCmd.AddCommand(eventsCmd)
+	eventsCmd.Flags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+	eventsCmd.Flags().StringP("task", "t", "", "Only list events of this task")
+	eventsCmd.Flags().String("state", "", "Only list events with this task state")
+	eventsCmd.Flags().String("since", "", "Only list events since this duration ago or RFC 3339 time")
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/wtran29/go-orchestrator/scheduler"
+)
+
+// explainCmd represents the explain command
+var explainCmd = &cobra.Command{
+	Use:   "explain [task-id]",
+	Short: "Explain scheduling decisions for a task.",
+	Long: `archon explain command.
+
+The explain command shows, for every node, which scheduler filters rejected
+a task and the score it was given. Pass the ID of an existing task (e.g. one
+stuck in Pending), or use --filename to dry-run a task specification without
+placing it.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		filename, _ := cmd.Flags().GetString("filename")
+
+		var resp *http.Response
+		var err error
+		switch {
+		case filename != "":
+			data, readErr := os.ReadFile(filename)
+			if readErr != nil {
+				log.Fatalf("Unable to read file: %v", filename)
+			}
+			url := fmt.Sprintf("http://%s/schedule/dry-run", manager)
+			resp, err = http.Post(url, "application/json", bytes.NewBuffer(data))
+		case len(args) == 1:
+			url := fmt.Sprintf("http://%s/tasks/%s/explain", manager, args[0])
+			resp, err = http.Get(url)
+		default:
+			log.Fatal("A task ID or --filename is required")
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("Error sending request: %v", resp.StatusCode)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var exp scheduler.Explanation
+		err = json.Unmarshal(body, &exp)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Task %s using scheduler %s\n", exp.TaskID, exp.Scheduler)
+		if exp.Error != "" {
+			fmt.Printf("Error: %s\n", exp.Error)
+		}
+		if exp.Selected != "" {
+			fmt.Printf("Would be placed on %s\n\n", exp.Selected)
+		} else {
+			fmt.Printf("No node can run the task\n\n")
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 5, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "NODE\tSCORE\tSELECTED\tREJECTED BY\t")
+		for _, n := range exp.Nodes {
+			score := "-"
+			if n.Score != nil {
+				score = fmt.Sprintf("%.4f", *n.Score)
+			}
+			var reasons []string
+			for _, r := range n.Rejected {
+				reasons = append(reasons, fmt.Sprintf("%s: %s", r.Filter, r.Reason))
+			}
+			fmt.Fprintf(w, "%s\t%s\t%t\t%s\t\n", n.Node, score, n.Selected, strings.Join(reasons, "; "))
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(explainCmd)
+	explainCmd.Flags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+	explainCmd.Flags().StringP("filename", "f", "", "Task specification file to dry-run")
+}
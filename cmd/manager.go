@@ -4,10 +4,14 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/wtran29/go-orchestrator/manager"
+	sched "github.com/wtran29/go-orchestrator/scheduler"
 )
 
 // managerCmd represents the manager command
@@ -27,9 +31,40 @@ The manager controls the orchestration system and is responsible for:
 		workers, _ := cmd.Flags().GetStringSlice("workers")
 		scheduler, _ := cmd.Flags().GetString("scheduler")
 		dbType, _ := cmd.Flags().GetString("dbType")
+		topologyKey, _ := cmd.Flags().GetString("topology-key")
+		maxSkew, _ := cmd.Flags().GetInt("max-skew")
+		profile, _ := cmd.Flags().GetString("scheduler-profile")
+		extenders, _ := cmd.Flags().GetStringSlice("extender")
+		extenderWeight, _ := cmd.Flags().GetFloat64("extender-weight")
+		extenderTimeout, _ := cmd.Flags().GetDuration("extender-timeout")
+		extenderIgnorable, _ := cmd.Flags().GetBool("extender-ignorable")
+
+		if _, err := sched.New(scheduler); err != nil {
+			log.Fatal(err)
+		}
 
 		log.Printf("Starting manager.")
 		m := manager.New(workers, scheduler, dbType)
+		if ts, ok := m.Scheduler.(*sched.TopologySpread); ok {
+			ts.TopologyKey = topologyKey
+			ts.MaxSkew = maxSkew
+		}
+		if profile != "" {
+			p, err := sched.LoadProfile(profile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("Using scheduler profile %s from %s", p.Name, profile)
+			m.Scheduler = p
+		}
+		for _, url := range extenders {
+			m.Extenders = append(m.Extenders, &sched.Extender{
+				URL:       url,
+				Weight:    extenderWeight,
+				Timeout:   extenderTimeout,
+				Ignorable: extenderIgnorable,
+			})
+		}
 		api := manager.Api{Address: host, Port: port, Manager: m}
 
 		go m.ProcessTasks()
@@ -46,7 +81,14 @@ func init() {
 	managerCmd.Flags().StringP("host", "H", "0.0.0.0", "Hostname or IP Address")
 	managerCmd.Flags().IntP("port", "p", 5555, "Port on which to listen")
 	managerCmd.Flags().StringSliceP("workers", "w", []string{"localhost:5556"}, "List of workers on which the manager will schedule tasks.")
-	managerCmd.Flags().StringP("scheduler", "s", "epvm", "Name of scheduler to use.")
+	managerCmd.Flags().StringP("scheduler", "s", "epvm", fmt.Sprintf("Name of scheduler to use (%s).", strings.Join(sched.Names(), ", ")))
+	managerCmd.Flags().String("scheduler-profile", "", "Scheduler profile file composing filter and score plugins; overrides --scheduler")
+	managerCmd.Flags().StringSlice("extender", nil, "URL of a scheduler extender to filter and score nodes")
+	managerCmd.Flags().Float64("extender-weight", 1, "Weight of the scores returned by extenders")
+	managerCmd.Flags().Duration("extender-timeout", 5*time.Second, "Timeout for each request to an extender")
+	managerCmd.Flags().Bool("extender-ignorable", false, "Schedule without an extender when it fails instead of failing the task's placement")
+	managerCmd.Flags().String("topology-key", "zone", "Node label used to spread tasks of a job when using the topology scheduler")
+	managerCmd.Flags().Int("max-skew", 1, "Maximum difference in a job's task count between topology domains")
 	managerCmd.Flags().StringP("dbType", "d", "memory", "Type of datastore to use for events and tasks (\"memory\" or \"persistent\")")
 
 }
@@ -10,6 +10,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"text/tabwriter"
 
 	"github.com/spf13/cobra"
@@ -26,7 +27,10 @@ The node command allows a user to get the information about the nodes in the clu
 	Run: func(cmd *cobra.Command, args []string) {
 		manager, _ := cmd.Flags().GetString("manager")
 		url := fmt.Sprintf("http://%s/nodes", manager)
-		resp, _ := http.Get(url)
+		resp, err := http.Get(url)
+		if err != nil {
+			log.Fatal(err)
+		}
 		defer resp.Body.Close()
 		body, err := io.ReadAll(resp.Body)
 		if err != nil {
@@ -35,9 +39,13 @@ The node command allows a user to get the information about the nodes in the clu
 		var nodes []*node.Node
 		json.Unmarshal(body, &nodes)
 		w := tabwriter.NewWriter(os.Stdout, 0, 0, 5, ' ', tabwriter.TabIndent)
-		fmt.Fprintln(w, "NAME\tMEMORY (MiB)\tDISK (GiB)\tROLE\tTASKS\t")
+		fmt.Fprintln(w, "NAME\tMEMORY (MiB)\tDISK (GiB)\tROLE\tTASKS\tTAINTS\t")
 		for _, node := range nodes {
-			fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t\n", node.Name, node.Memory/1000, node.Disk/1000/1000/1000, node.Role, node.TaskCount)
+			var taints []string
+			for _, t := range node.AllTaints() {
+				taints = append(taints, t.String())
+			}
+			fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%s\t\n", node.Name, node.Memory/1000, node.Disk/1000/1000/1000, node.Role, node.TaskCount, strings.Join(taints, ","))
 		}
 		w.Flush()
 	},
@@ -0,0 +1,81 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/wtran29/go-orchestrator/scheduler"
+	"github.com/wtran29/go-orchestrator/simulator"
+)
+
+// simulateCmd represents the simulate command
+var simulateCmd = &cobra.Command{
+	Use:   "simulate",
+	Short: "Simulate schedulers against a trace of tasks.",
+	Long: `archon simulate command.
+
+The simulate command replays a trace of task arrivals and durations against a
+synthetic cluster on a virtual clock, and reports utilization, fragmentation,
+queueing delay and placement failures for each scheduler given.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		clusterFile, _ := cmd.Flags().GetString("cluster")
+		traceFile, _ := cmd.Flags().GetString("trace")
+		schedulers, _ := cmd.Flags().GetStringSlice("scheduler")
+		profiles, _ := cmd.Flags().GetStringSlice("scheduler-profile")
+
+		trace, err := simulator.LoadTrace(traceFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var reports []simulator.Report
+		run := func(name string, s scheduler.Scheduler) {
+			// every run starts from an empty copy of the cluster
+			nodes, err := simulator.LoadCluster(clusterFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			sim := simulator.Simulator{Scheduler: s, Nodes: nodes, Trace: trace}
+			reports = append(reports, sim.Run(name))
+		}
+		for _, name := range schedulers {
+			s, err := scheduler.New(name)
+			if err != nil {
+				log.Fatal(err)
+			}
+			run(name, s)
+		}
+		for _, profile := range profiles {
+			p, err := scheduler.LoadProfile(profile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			run(p.Name, p)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 5, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "SCHEDULER\tPLACED\tUNPLACED\tFAILED ATTEMPTS\tCPU UTIL\tMEM UTIL\tFRAGMENTATION\tMEAN DELAY (s)\tMAX DELAY (s)\tMAKESPAN (s)\t")
+		for _, r := range reports {
+			fmt.Fprintf(w, "%s\t%d/%d\t%d\t%d\t%.1f%%\t%.1f%%\t%.1f%%\t%.1f\t%.1f\t%.1f\t\n",
+				r.Scheduler, r.Placed, r.Tasks, r.Unplaced, r.PlacementFailures,
+				r.CpuUtilization*100, r.MemUtilization*100, r.Fragmentation*100,
+				r.MeanQueueDelay, r.MaxQueueDelay, r.Makespan)
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(simulateCmd)
+	simulateCmd.Flags().StringP("cluster", "c", "cluster.json", "File listing the simulated nodes")
+	simulateCmd.Flags().StringP("trace", "t", "trace.json", "File listing task arrivals and durations")
+	simulateCmd.Flags().StringSliceP("scheduler", "s", []string{"roundrobin", "epvm"}, fmt.Sprintf("Schedulers to simulate (%s).", strings.Join(scheduler.Names(), ", ")))
+	simulateCmd.Flags().StringSlice("scheduler-profile", nil, "Scheduler profile files to simulate")
+}
@@ -4,17 +4,21 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"sort"
+	"strings"
 	"text/tabwriter"
 	"time"
 
 	"github.com/docker/go-units"
 	"github.com/spf13/cobra"
+	"github.com/wtran29/go-orchestrator/manager"
 	"github.com/wtran29/go-orchestrator/task"
 )
 
@@ -24,9 +28,15 @@ var statusCmd = &cobra.Command{
 	Short: "Status comman to list tasks",
 	Long: `archon status command.
 
-The status command allows a user to get the status of tasks from the Archon manager.`,
+The status command allows a user to get the status of tasks from the Archon manager.
+With --watch the table is kept up to date as tasks change.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		manager, _ := cmd.Flags().GetString("manager")
+		watch, _ := cmd.Flags().GetBool("watch")
+		if watch {
+			watchTasks(manager)
+			return
+		}
 		url := fmt.Sprintf("http://%s/tasks", manager)
 		resp, _ := http.Get(url)
 		body, err := io.ReadAll(resp.Body)
@@ -40,25 +50,111 @@ The status command allows a user to get the status of tasks from the Archon mana
 		if err != nil {
 			log.Fatal(err)
 		}
-		w := tabwriter.NewWriter(os.Stdout, 0, 0, 5, ' ', tabwriter.TabIndent)
-		fmt.Fprintln(w, "ID\tNAME\tCREATED\tSTATE\tCONTAINERNAME\tIMAGE\t")
-		for _, task := range tasks {
-			var start string
-			if task.StartTime.IsZero() {
-				start = fmt.Sprintf("%s ago", units.HumanDuration(time.Now().UTC().Sub(time.Now().UTC())))
-			} else {
-				start = fmt.Sprintf("%s ago", units.HumanDuration(time.Now().UTC().Sub(task.StartTime)))
-			}
-			state := task.State.String()[task.State]
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t\n", task.ID, task.Name, start, state, task.Name, task.Image)
+		printTasks(tasks)
+	},
+}
 
+func printTasks(tasks []*task.Task) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 5, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "ID\tNAME\tCREATED\tSTATE\tREADY\tRESTARTS\tNEXT RETRY\tCONTAINERNAME\tIMAGE\tREASON\tMESSAGE\t")
+	for _, task := range tasks {
+		var start string
+		if task.StartTime.IsZero() {
+			start = fmt.Sprintf("%s ago", units.HumanDuration(time.Now().UTC().Sub(time.Now().UTC())))
+		} else {
+			start = fmt.Sprintf("%s ago", units.HumanDuration(time.Now().UTC().Sub(task.StartTime)))
 		}
-		w.Flush()
-	},
+		state := task.State.String()
+		retry := "-"
+		if !task.NextRetry.IsZero() {
+			retry = fmt.Sprintf("in %s", units.HumanDuration(time.Until(task.NextRetry)))
+		}
+		reason := task.Reason
+		if reason == "" {
+			reason = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%d\t%s\t%s\t%s\t%s\t%s\t\n", task.ID, task.Name, start, state, task.Ready, task.RestartCount, retry, task.Name, task.Image, reason, task.StatusMessage)
+
+	}
+	w.Flush()
+}
+
+// watchTasks redraws the task table every time a task changes. When the
+// stream is interrupted it resumes from the last revision it saw.
+func watchTasks(mgr string) {
+	tasks := make(map[string]*task.Task)
+	var revision uint64
+	for {
+		err := streamTasks(mgr, &revision, tasks)
+		if err != nil {
+			log.Printf("Watch interrupted: %v", err)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func streamTasks(mgr string, revision *uint64, tasks map[string]*task.Task) error {
+	url := fmt.Sprintf("http://%s/watch?type=task&revision=%d", mgr, *revision)
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone {
+		// too far behind to resume, start again from the current state
+		*revision = 0
+		for id := range tasks {
+			delete(tasks, id)
+		}
+		return fmt.Errorf("revision too old, reloading tasks")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error sending request: %v", resp.StatusCode)
+	}
+
+	redrawTasks(tasks)
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\n")
+		// a blank line ends an event; redraw once every change received so
+		// far has been applied
+		if line == "" && reader.Buffered() == 0 {
+			redrawTasks(tasks)
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		var e manager.WatchEvent
+		err = json.Unmarshal([]byte(data), &e)
+		if err != nil {
+			return err
+		}
+		*revision = e.Revision
+		if e.Task != nil {
+			tasks[e.Task.ID.String()] = e.Task
+		}
+	}
+}
+
+func redrawTasks(tasks map[string]*task.Task) {
+	var list []*task.Task
+	for _, t := range tasks {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID.String() < list[j].ID.String() })
+	// clear the screen and move the cursor home
+	fmt.Print("\033[H\033[2J")
+	printTasks(list)
 }
 
 func init() {
 	rootCmd.AddCommand(statusCmd)
 	statusCmd.Flags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
-
+	statusCmd.Flags().BoolP("watch", "w", false, "Keep watching for task changes")
 }
@@ -0,0 +1,67 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/wtran29/go-orchestrator/task"
+)
+
+// taintCmd represents the taint command
+var taintCmd = &cobra.Command{
+	Use:   "taint NODE key=value:effect | key[:effect]-",
+	Short: "Add or remove a taint on a node.",
+	Long: `archon taint command.
+
+The taint command adds a taint to a node, e.g. team=ml:NoSchedule, or removes
+the taints with a key when it is suffixed with a dash, e.g. team-. The effect
+is one of NoSchedule, PreferNoSchedule or NoExecute. Tasks already running on
+a node that do not tolerate a new NoExecute taint are moved to another node.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		nodeName := args[0]
+
+		var req *http.Request
+		var err error
+		if strings.HasSuffix(args[1], "-") {
+			key, effect, _ := strings.Cut(strings.TrimSuffix(args[1], "-"), ":")
+			u := fmt.Sprintf("http://%s/nodes/%s/taints/%s?effect=%s", manager, nodeName, url.PathEscape(key), url.QueryEscape(effect))
+			req, err = http.NewRequest("DELETE", u, nil)
+		} else {
+			taint, parseErr := task.ParseTaint(args[1])
+			if parseErr != nil {
+				log.Fatal(parseErr)
+			}
+			data, _ := json.Marshal(taint)
+			u := fmt.Sprintf("http://%s/nodes/%s/taints", manager, nodeName)
+			req, err = http.NewRequest("POST", u, bytes.NewBuffer(data))
+		}
+		if err != nil {
+			log.Fatalf("Error creating request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", manager, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			log.Fatalf("Error sending request: %v", resp.StatusCode)
+		}
+		log.Printf("Node %s updated.", nodeName)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(taintCmd)
+	taintCmd.Flags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+}
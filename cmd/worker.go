@@ -6,9 +6,10 @@ package cmd
 import (
 	"fmt"
 	"log"
+	"os"
 
-	"github.com/google/uuid"
 	"github.com/spf13/cobra"
+	"github.com/wtran29/go-orchestrator/task"
 	"github.com/wtran29/go-orchestrator/worker"
 )
 
@@ -18,19 +19,79 @@ var workerCmd = &cobra.Command{
 	Short: "Worker command to operate an Archon worker node.",
 	Long: `archon worker command. 
 
-The worker runs tasks and responds to the managers's requests about task state.`,
+The worker runs tasks and responds to the managers's requests about task state.
+With --manager the worker also pushes task state changes to the manager as
+they happen, instead of waiting for the manager to poll for them. With --pull
+as well, the worker polls the manager for its work too, so the manager never
+connects to it, e.g. when the worker is behind NAT.
+
+On startup the worker adopts the containers it created before it restarted,
+finding them by the worker's --name. The name defaults to one made from the
+hostname and port, so it stays the same across restarts.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		host, _ := cmd.Flags().GetString("host")
 		port, _ := cmd.Flags().GetInt("port")
 		name, _ := cmd.Flags().GetString("name")
 		dbType, _ := cmd.Flags().GetString("dbtype")
+		labels, _ := cmd.Flags().GetStringToString("labels")
+		taintFlags, _ := cmd.Flags().GetStringSlice("taints")
+		mgr, _ := cmd.Flags().GetString("manager")
+		advertise, _ := cmd.Flags().GetString("advertise")
+		pull, _ := cmd.Flags().GetBool("pull")
+		cleanup, _ := cmd.Flags().GetBool("cleanup-orphans")
+		executors, _ := cmd.Flags().GetInt("executors")
+		maxTasks, _ := cmd.Flags().GetInt("max-tasks")
+		reservedMemory, _ := cmd.Flags().GetInt64("reserved-memory")
+		reservedCpu, _ := cmd.Flags().GetFloat64("reserved-cpu")
+		if pull && mgr == "" {
+			log.Fatal("--pull requires --manager")
+		}
+		if name == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				log.Fatalf("unable to name worker after its host, set --name: %v", err)
+			}
+			name = fmt.Sprintf("worker-%s-%d", hostname, port)
+		}
+		if advertise == "" {
+			advertise = fmt.Sprintf("%s:%d", host, port)
+			if host == "0.0.0.0" {
+				advertise = fmt.Sprintf("localhost:%d", port)
+			}
+		}
+		var taints []task.Taint
+		for _, tf := range taintFlags {
+			taint, err := task.ParseTaint(tf)
+			if err != nil {
+				log.Fatal(err)
+			}
+			taints = append(taints, taint)
+		}
 
 		log.Println("Starting worker.")
 		w := worker.New(name, dbType)
+		w.Labels = labels
+		w.Taints = taints
+		w.Manager = mgr
+		w.Address = advertise
+		w.Executors = executors
+		w.MaxTasks = maxTasks
+		w.ReservedMemory = reservedMemory
+		w.ReservedCpu = reservedCpu
 		api := worker.Api{Address: host, Port: port, Worker: w}
+		err := w.Reconcile(cleanup)
+		if err != nil {
+			log.Printf("Unable to reconcile containers: %v", err)
+		}
 		go w.RunTasks()
 		go w.CollectStats()
 		go w.UpdateTasks()
+		if mgr != "" {
+			go w.PushUpdates()
+		}
+		if pull {
+			go w.Pull()
+		}
 		log.Printf("Starting worker API on http://%s:%d", host, port)
 		api.Start()
 	},
@@ -41,7 +102,17 @@ func init() {
 	workerCmd.Flags().StringP("host", "H", "0.0.0.0", "Hostname or IP address")
 	workerCmd.Flags().IntP("port", "p", 5556, "Port on which to listen")
 
-	workerCmd.Flags().StringP("name", "n", fmt.Sprintf("worker-%s", uuid.New().String()), "Name of the worker")
+	workerCmd.Flags().StringP("name", "n", "", "Name of the worker, which finds its containers after a restart (default worker-<hostname>-<port>)")
 	workerCmd.Flags().StringP("dbtype", "d", "memory", "Type of datastore to use for tasks (\"memory\" or \"persistent\")")
+	workerCmd.Flags().StringSlice("taints", nil, "Taints repelling tasks that do not tolerate them (e.g. team=ml:NoSchedule)")
+	workerCmd.Flags().StringToStringP("labels", "l", nil, "Labels describing the node's topology (e.g. zone=us-east-1a)")
+	workerCmd.Flags().StringP("manager", "m", "", "Manager to push task state changes to (e.g. localhost:5555)")
+	workerCmd.Flags().Bool("pull", false, "Poll the manager for work instead of waiting to be sent it")
+	workerCmd.Flags().Int("executors", worker.DefaultExecutors, "How many tasks to start or stop at once")
+	workerCmd.Flags().Int("max-tasks", 0, "Most tasks to run at once, turning the rest away (0 for no limit)")
+	workerCmd.Flags().Int64("reserved-memory", 0, "Memory in bytes kept back for the system rather than tasks")
+	workerCmd.Flags().Float64("reserved-cpu", 0, "Cpus kept back for the system rather than tasks")
+	workerCmd.Flags().Bool("cleanup-orphans", false, "On startup, remove containers the worker created for tasks it no longer runs")
+	workerCmd.Flags().String("advertise", "", "Address the manager knows the worker by, as given to the manager's --workers (default host:port)")
 
 }
@@ -26,10 +26,31 @@ func (a *Api) initRouter() {
 		r.Get("/", a.GetTasksHandler)
 		r.Route("/{taskID}", func(r chi.Router) {
 			r.Delete("/", a.StopTaskHandler)
+			r.Get("/explain", a.ExplainTaskHandler)
+			r.Get("/events", a.GetTaskEventsHandler)
+			r.Post("/status", a.TaskStatusHandler)
 		})
 	})
+	a.Router.Route("/events", func(r chi.Router) {
+		r.Get("/", a.GetEventsHandler)
+	})
+	a.Router.Get("/watch", a.WatchHandler)
+	a.Router.Route("/workers/{name}", func(r chi.Router) {
+		r.Post("/poll", a.PollHandler)
+		r.Post("/containers", a.ClaimContainersHandler)
+	})
+	a.Router.Route("/schedule", func(r chi.Router) {
+		r.Post("/dry-run", a.DryRunHandler)
+	})
 	a.Router.Route("/nodes", func(r chi.Router) {
 		r.Get("/", a.GetNodesHandler)
+		r.Route("/{nodeName}/taints", func(r chi.Router) {
+			r.Post("/", a.AddTaintHandler)
+			r.Delete("/{key}", a.RemoveTaintHandler)
+		})
+	})
+	a.Router.Route("/services", func(r chi.Router) {
+		r.Get("/{name}/endpoints", a.GetEndpointsHandler)
 	})
 }
 
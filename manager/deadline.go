@@ -0,0 +1,59 @@
+package manager
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/wtran29/go-orchestrator/task"
+)
+
+// enforceActiveDeadline stops a running task that has run for longer than its
+// ActiveDeadlineSeconds. The task is not restarted afterwards.
+func (m *Manager) enforceActiveDeadline(t *task.Task) {
+	if !t.ActiveDeadlineExceeded(time.Now().UTC()) {
+		return
+	}
+	log.Printf("[manager] task %s ran longer than its %ds deadline, stopping it\n", t.ID, t.ActiveDeadlineSeconds)
+	err := m.setState(t, task.Stopping, task.ReasonDeadlineExceeded, fmt.Sprintf("ran longer than the deadline of %ds", t.ActiveDeadlineSeconds))
+	if err != nil {
+		log.Printf("[manager] %v\n", err)
+		return
+	}
+	t.NextRetry = time.Time{}
+	m.TaskDb.Put(t.ID.String(), t)
+	m.stopTask(m.TaskWorkerMap[t.ID], t.ID.String())
+}
+
+// failUnschedulable fails a task that could not be placed within its
+// SchedulingDeadlineSeconds. It returns false if the task still has time left.
+func (m *Manager) failUnschedulable(t task.Task) bool {
+	if !t.SchedulingDeadlineExceeded(time.Now().UTC()) {
+		return false
+	}
+	log.Printf("[manager] task %s could not be scheduled within %ds, failing it\n", t.ID, t.SchedulingDeadlineSeconds)
+	err := m.setState(&t, task.Failed, task.ReasonSchedulingDeadlineExceeded, fmt.Sprintf("could not be scheduled within %ds", t.SchedulingDeadlineSeconds))
+	if err != nil {
+		log.Printf("[manager] %v\n", err)
+		return false
+	}
+	t.FinishTime = time.Now().UTC()
+	m.TaskDb.Put(t.ID.String(), &t)
+	return true
+}
+
+// expireGangMembers fails the members of a gang that have waited longer than
+// their scheduling deadline, so the gang waits for replacements instead
+func (m *Manager) expireGangMembers(job string, g *gang) {
+	var remaining []task.TaskEvent
+	for _, te := range g.Members {
+		if m.failUnschedulable(te.Task) {
+			continue
+		}
+		remaining = append(remaining, te)
+	}
+	if len(remaining) != len(g.Members) {
+		log.Printf("[manager] gang %s lost %d tasks to their scheduling deadline\n", job, len(g.Members)-len(remaining))
+	}
+	g.Members = remaining
+}
@@ -0,0 +1,34 @@
+package manager
+
+import (
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/wtran29/go-orchestrator/task"
+)
+
+// Endpoint is the address of a ready task that serves traffic for a job
+type Endpoint struct {
+	TaskID  uuid.UUID
+	Address string
+}
+
+// Endpoints returns the addresses of the running and ready tasks of the named
+// job. Tasks failing their readiness probe are left out until they pass again.
+func (m *Manager) Endpoints(name string) []Endpoint {
+	m.mu.Lock()
+	defer m.unlock()
+	endpoints := []Endpoint{}
+	for _, t := range m.getTasks() {
+		if t.JobName() != name || t.State != task.Running || !t.Ready {
+			continue
+		}
+		addr, err := m.taskAddress(*t, m.TaskWorkerMap[t.ID], "")
+		if err != nil {
+			log.Printf("Skipping endpoint for task %s: %v\n", t.ID, err)
+			continue
+		}
+		endpoints = append(endpoints, Endpoint{TaskID: t.ID, Address: addr})
+	}
+	return endpoints
+}
@@ -0,0 +1,86 @@
+package manager
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wtran29/go-orchestrator/task"
+)
+
+// EventFilter selects events from the manager's event history. Zero fields
+// match every event.
+type EventFilter struct {
+	TaskID uuid.UUID
+	State  *task.State
+	Since  time.Time
+	Until  time.Time
+}
+
+func (f EventFilter) matches(te *task.TaskEvent) bool {
+	switch {
+	case f.TaskID != uuid.Nil && te.Task.ID != f.TaskID:
+		return false
+	case f.State != nil && te.State != *f.State:
+		return false
+	case !f.Since.IsZero() && te.Timestamp.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && te.Timestamp.After(f.Until):
+		return false
+	}
+	return true
+}
+
+// GetEvents returns the events that match the filter, oldest first
+func (m *Manager) GetEvents(f EventFilter) ([]*task.TaskEvent, error) {
+	m.mu.Lock()
+	defer m.unlock()
+	result, err := m.EventDb.List()
+	if err != nil {
+		return nil, fmt.Errorf("error getting list of events: %v", err)
+	}
+	events := []*task.TaskEvent{}
+	for _, te := range result.([]*task.TaskEvent) {
+		if f.matches(te) {
+			c := *te
+			events = append(events, &c)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Timestamp.Before(events[j].Timestamp) })
+	return events, nil
+}
+
+// recordEvent adds something that happened to the task, such as a failed
+// health check, to the event history
+func (m *Manager) recordEvent(t task.Task, reason string, message string) {
+	te := task.TaskEvent{
+		ID:        uuid.New(),
+		State:     t.State,
+		Timestamp: time.Now().UTC(),
+		Task:      t,
+		Reason:    reason,
+		Message:   message,
+	}
+	err := m.EventDb.Put(te.ID.String(), &te)
+	if err != nil {
+		log.Printf("error attempting to store task event %s: %s\n", te.ID.String(), err)
+	}
+}
+
+// setState moves the task to state s and records the transition as an event.
+// Repeating the task's current state and reason, e.g. while it stays
+// unschedulable, is not recorded again. A move the task's state machine does
+// not allow is refused, leaving the task as it was.
+func (m *Manager) setState(t *task.Task, s task.State, reason string, message string) error {
+	if t.State == s && t.Reason == reason && t.StatusMessage == message {
+		return nil
+	}
+	if !task.ValidStateTransition(t.State, s) {
+		return fmt.Errorf("invalid transition of task %s from %v to %v", t.ID, t.State, s)
+	}
+	t.SetState(s, reason, message)
+	m.recordEvent(*t, reason, message)
+	return nil
+}
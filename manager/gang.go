@@ -0,0 +1,243 @@
+package manager
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wtran29/go-orchestrator/node"
+	"github.com/wtran29/go-orchestrator/task"
+)
+
+const (
+	gangBaseBackoff = 10 * time.Second
+	gangMaxBackoff  = 5 * time.Minute
+)
+
+// gang holds the tasks of a job that must be started together
+type gang struct {
+	Size     int
+	Members  []task.TaskEvent
+	Attempts int       // failed attempts to place every member
+	NextTry  time.Time // when placement will next be attempted
+	placing  bool      // whether the gang is being placed and sent without holding mu
+}
+
+// addGangMember holds a task of a gang job until all of its members have
+// been submitted and can be placed at once
+func (m *Manager) addGangMember(te task.TaskEvent) {
+	job := te.Task.JobName()
+	g, ok := m.gangs[job]
+	if !ok {
+		g = &gang{Size: te.Task.GangSize}
+		m.gangs[job] = g
+	}
+	for _, member := range g.Members {
+		if member.Task.ID == te.Task.ID {
+			log.Printf("[manager] task %s is already a member of gang %s\n", te.Task.ID, job)
+			return
+		}
+	}
+
+	t := te.Task
+	err := m.setState(&t, task.Pending, task.ReasonSubmitted, fmt.Sprintf("waiting for %d of %d tasks of gang %s", g.Size-len(g.Members)-1, g.Size, job))
+	if err != nil {
+		log.Printf("[manager] %v\n", err)
+		return
+	}
+	g.Members = append(g.Members, te)
+	m.TaskDb.Put(t.ID.String(), &t)
+	log.Printf("[manager] gang %s has %d of %d tasks\n", job, len(g.Members), g.Size)
+}
+
+// SendGangs starts every gang whose members have all been submitted, if a
+// placement can be found for all of them at once
+func (m *Manager) SendGangs() {
+	m.mu.Lock()
+	var ready []string
+	for job, g := range m.gangs {
+		if g.placing {
+			continue
+		}
+		m.expireGangMembers(job, g)
+		if len(g.Members) < g.Size || time.Now().Before(g.NextTry) {
+			continue
+		}
+		ready = append(ready, job)
+	}
+	m.unlock()
+	for _, job := range ready {
+		m.sendGang(job)
+	}
+}
+
+// sendGang places and sends every member of the gang, or none of them. The
+// gang is placed and sent without holding mu, which is taken to assign the
+// members to their workers and to undo that if any of them fails to start.
+func (m *Manager) sendGang(job string) {
+	m.mu.Lock()
+	g, ok := m.gangs[job]
+	if !ok || g.placing {
+		m.unlock()
+		return
+	}
+	g.placing = true
+	members := append([]task.TaskEvent(nil), g.Members...)
+	nodes := m.nodeSnapshot()
+	m.unlock()
+
+	placements, err := m.placeGang(job, members, nodes)
+	m.mu.Lock()
+	if err == nil && !g.has(members) {
+		err = fmt.Errorf("members of gang %s changed while it was placed", job)
+	}
+	if err != nil {
+		log.Printf("[manager] %v\n", err)
+		g.placing = false
+		m.retryGang(job, g)
+		m.unlock()
+		return
+	}
+	events := make([]task.TaskEvent, len(members))
+	for i, te := range members {
+		events[i], err = m.assignTask(te, placements[i])
+		if err != nil {
+			log.Printf("[manager] failed to assign task %s of gang %s: %v\n", te.Task.ID, job, err)
+			// none of the members assigned so far have been sent
+			unsent := make([]error, i)
+			for j := range unsent {
+				unsent[j] = err
+			}
+			m.rollbackGang(job, g, members[:i], placements, unsent, err)
+			m.unlock()
+			return
+		}
+	}
+	m.unlock()
+
+	errs := make([]error, len(members))
+	var failed error
+	for i, te := range events {
+		errs[i] = m.sendTask(placements[i], te)
+		if errs[i] != nil && failed == nil {
+			log.Printf("[manager] failed to start task %s of gang %s: %v\n", te.Task.ID, job, errs[i])
+			failed = fmt.Errorf("task %s of the gang failed to start: %v", te.Task.ID, errs[i])
+		}
+	}
+
+	m.mu.Lock()
+	defer m.unlock()
+	if failed != nil {
+		m.rollbackGang(job, g, members, placements, errs, failed)
+		return
+	}
+	for i, te := range members {
+		m.recordSend(te, placements[i], nil)
+		// once started, a member that is evicted or moved is placed
+		// again on its own rather than waiting for a new gang
+		m.gangStarted[te.Task.ID] = true
+	}
+	g.remove(members)
+	g.placing = false
+	if len(g.Members) == 0 && m.gangs[job] == g {
+		delete(m.gangs, job)
+	}
+}
+
+// placeGang selects a worker for every member of a gang, on nodes, copies of
+// the worker nodes. Capacity is reserved on the copies for every member
+// before the next is placed, so members see the resources taken by the ones
+// placed before them.
+func (m *Manager) placeGang(job string, members []task.TaskEvent, nodes []*node.Node) ([]string, error) {
+	placements := make([]string, len(members))
+	for i, te := range members {
+		w, err := m.selectWorker(te.Task, nodes)
+		if err != nil || w == nil {
+			return nil, fmt.Errorf("no placement for task %s of gang %s: %v", te.Task.ID, job, err)
+		}
+		w.Allocate(te.Task)
+		placements[i] = w.Name
+	}
+	return placements, nil
+}
+
+// rollbackGang undoes the assignment of the gang's members, stopping those
+// that were sent, rather than leave the gang half running, and puts them back
+// to pending to be placed again after a backoff. errs holds how sending each
+// member went.
+func (m *Manager) rollbackGang(job string, g *gang, members []task.TaskEvent, placements []string, errs []error, cause error) {
+	message := cause.Error()
+	for i, te := range members {
+		id := te.Task.ID
+		delete(m.sending, id)
+		if m.TaskWorkerMap[id] != placements[i] {
+			// moved off the worker in the meantime, e.g. evicted
+			if errs[i] == nil {
+				m.stopTask(placements[i], id.String())
+			}
+			continue
+		}
+		result, err := m.TaskDb.Get(id.String())
+		if err != nil {
+			continue
+		}
+		t := result.(*task.Task)
+		if errs[i] == nil {
+			m.stopTask(placements[i], id.String())
+		}
+		m.releaseTask(t)
+		m.unassignTask(placements[i], id)
+		if t.State == task.Stopping {
+			// stopped while the gang was sent, so it leaves the gang
+			g.remove([]task.TaskEvent{te})
+			m.cancelPending(id)
+			continue
+		}
+		if err := m.setState(t, task.Pending, task.ReasonGangRolledBack, message); err != nil {
+			log.Printf("[manager] %v\n", err)
+			continue
+		}
+		m.TaskDb.Put(t.ID.String(), t)
+	}
+	g.placing = false
+	m.retryGang(job, g)
+}
+
+// retryGang backs off before the gang is next placed
+func (m *Manager) retryGang(job string, g *gang) {
+	g.Attempts++
+	backoff := min(gangBaseBackoff<<min(g.Attempts-1, 10), gangMaxBackoff)
+	g.NextTry = time.Now().Add(backoff)
+	log.Printf("[manager] unable to start gang %s, retrying in %v\n", job, backoff)
+}
+
+// has reports whether every one of members is still a member of the gang
+func (g *gang) has(members []task.TaskEvent) bool {
+	for _, te := range members {
+		if !containsTask(g.Members, te.Task.ID) {
+			return false
+		}
+	}
+	return true
+}
+
+// remove takes members out of the gang
+func (g *gang) remove(members []task.TaskEvent) {
+	var remaining []task.TaskEvent
+	for _, member := range g.Members {
+		if !containsTask(members, member.Task.ID) {
+			remaining = append(remaining, member)
+		}
+	}
+	g.Members = remaining
+}
+
+func containsTask(events []task.TaskEvent, id uuid.UUID) bool {
+	for _, te := range events {
+		if te.Task.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -5,11 +5,13 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/wtran29/go-orchestrator/task"
+	"github.com/wtran29/go-orchestrator/worker"
 )
 
 func (a *Api) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
@@ -48,7 +50,7 @@ func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(400)
 	}
 	tid, _ := uuid.Parse(taskID)
-	taskToStop, err := a.Manager.TaskDb.Get(tid.String())
+	taskToStop, err := a.Manager.GetTask(tid)
 	if err != nil {
 		log.Printf("No task with ID %v found", tid)
 		w.WriteHeader(404)
@@ -60,8 +62,8 @@ func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
 		Timestamp: time.Now(),
 	}
 
-	// need to make a copy so we are not modifying the task in the datastore
-	taskCopy := *taskToStop.(*task.Task)
+	// GetTask returns a copy, so the task in the datastore is not modified
+	taskCopy := *taskToStop
 	// taskCopy.State = task.Completed
 	te.Task = taskCopy
 	a.Manager.AddTask(te)
@@ -73,5 +75,326 @@ func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
 func (a *Api) GetNodesHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
-	json.NewEncoder(w).Encode(a.Manager.WorkerNodes)
+	json.NewEncoder(w).Encode(a.Manager.GetNodes())
+}
+
+// DryRunHandler runs the scheduler on the task in the request body without
+// placing it and returns the outcome for every node
+func (a *Api) DryRunHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	te := task.TaskEvent{}
+	err := d.Decode(&te)
+	if err != nil {
+		msg := fmt.Sprintf("Error unmarshalling body: %v\n", err)
+		log.Println(msg)
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(a.Manager.ExplainTask(te.Task))
+}
+
+// ExplainTaskHandler explains how the scheduler treats an existing task,
+// e.g. to find out why it is stuck in Pending
+func (a *Api) ExplainTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskID := chi.URLParam(r, "taskID")
+	tid, _ := uuid.Parse(taskID)
+	t, err := a.Manager.GetTask(tid)
+	if err != nil {
+		log.Printf("No task with ID %v found", tid)
+		w.WriteHeader(404)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(a.Manager.ExplainTask(*t))
+}
+
+// AddTaintHandler sets the taint in the request body on a node
+func (a *Api) AddTaintHandler(w http.ResponseWriter, r *http.Request) {
+	nodeName := chi.URLParam(r, "nodeName")
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	taint := task.Taint{}
+	err := d.Decode(&taint)
+	if err == nil {
+		err = a.Manager.TaintNode(nodeName, taint)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("Error tainting node %s: %v\n", nodeName, err)
+		log.Println(msg)
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// RemoveTaintHandler removes the taints with a key from a node, optionally
+// only those with the effect given in the effect query parameter
+func (a *Api) RemoveTaintHandler(w http.ResponseWriter, r *http.Request) {
+	nodeName := chi.URLParam(r, "nodeName")
+	key := chi.URLParam(r, "key")
+	err := a.Manager.UntaintNode(nodeName, key, r.URL.Query().Get("effect"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(404)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// GetEndpointsHandler lists the addresses of the ready tasks of a job
+func (a *Api) GetEndpointsHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(a.Manager.Endpoints(name))
+}
+
+// GetTaskEventsHandler lists the events of a single task, oldest first
+func (a *Api) GetTaskEventsHandler(w http.ResponseWriter, r *http.Request) {
+	taskID := chi.URLParam(r, "taskID")
+	tid, err := uuid.Parse(taskID)
+	if err != nil {
+		log.Printf("Invalid task ID %v\n", taskID)
+		w.WriteHeader(400)
+		return
+	}
+	a.writeEvents(w, EventFilter{TaskID: tid})
+}
+
+// GetEventsHandler lists events, filtered by the task, state, since and until
+// query parameters. Times are in RFC 3339 format.
+func (a *Api) GetEventsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		msg := fmt.Sprintf("Invalid event filter: %v\n", err)
+		log.Println(msg)
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+	a.writeEvents(w, filter)
+}
+
+func (a *Api) writeEvents(w http.ResponseWriter, filter EventFilter) {
+	events, err := a.Manager.GetEvents(filter)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(events)
+}
+
+func parseEventFilter(r *http.Request) (EventFilter, error) {
+	var filter EventFilter
+	q := r.URL.Query()
+	var err error
+	if v := q.Get("task"); v != "" {
+		filter.TaskID, err = uuid.Parse(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid task ID %q: %v", v, err)
+		}
+	}
+	if v := q.Get("state"); v != "" {
+		state, err := task.ParseState(v)
+		if err != nil {
+			return filter, err
+		}
+		filter.State = &state
+	}
+	if v := q.Get("since"); v != "" {
+		filter.Since, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid since time %q: %v", v, err)
+		}
+	}
+	if v := q.Get("until"); v != "" {
+		filter.Until, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid until time %q: %v", v, err)
+		}
+	}
+	return filter, nil
+}
+
+// WatchHandler streams task and node changes as server-sent events. Each
+// event's id is its revision; a client resumes after a revision with the
+// revision query parameter or the Last-Event-ID header, and otherwise first
+// receives the current state of every task and node. The type query
+// parameter limits the stream to "task" or "node" changes.
+func (a *Api) WatchHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(500)
+		return
+	}
+	from := r.URL.Query().Get("revision")
+	if from == "" {
+		from = r.Header.Get("Last-Event-ID")
+	}
+	var revision uint64
+	if from != "" {
+		var err error
+		revision, err = strconv.ParseUint(from, 10, 64)
+		if err != nil {
+			log.Printf("Invalid watch revision %v\n", from)
+			w.WriteHeader(400)
+			return
+		}
+	}
+	missed, changes, stop, err := a.Manager.Watch(revision)
+	if err != nil {
+		w.WriteHeader(410)
+		e := ErrResponse{
+			HTTPStatusCode: 410,
+			Message:        err.Error(),
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+	defer stop()
+
+	kind := r.URL.Query().Get("type")
+	send := func(e WatchEvent) error {
+		if kind != "" && e.Type != kind {
+			return nil
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Revision, e.Type, data)
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(200)
+	for _, e := range missed {
+		if send(e) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-changes:
+			if !ok {
+				// the watcher fell behind and was dropped
+				return
+			}
+			if send(e) != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// TaskStatusHandler accepts a task's state pushed by the worker running it
+func (a *Api) TaskStatusHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	report := worker.StatusReport{}
+	err := d.Decode(&report)
+	if err == nil && !a.Manager.isWorker(report.Worker) {
+		err = fmt.Errorf("unknown worker %q", report.Worker)
+	}
+	if err == nil && report.Task.ID.String() != chi.URLParam(r, "taskID") {
+		err = fmt.Errorf("status is for task %v, not %s", report.Task.ID, chi.URLParam(r, "taskID"))
+	}
+	if err != nil {
+		msg := fmt.Sprintf("Error accepting task status: %v\n", err)
+		log.Println(msg)
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+	a.Manager.ReportTaskStatus(report.Worker, &report.Task)
+	w.WriteHeader(204)
+}
+
+// PollHandler hands work to a worker that pulls it from the manager. The poll
+// is held open until there is work for the worker or it times out.
+func (a *Api) PollHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	d := json.NewDecoder(r.Body)
+	req := worker.PollRequest{}
+	err := d.Decode(&req)
+	var work []task.TaskEvent
+	if err == nil {
+		work, err = a.Manager.Poll(r.Context(), name, req)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("Error polling for work: %v\n", err)
+		log.Println(msg)
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	err = json.NewEncoder(w).Encode(worker.PollResponse{Work: work})
+	if err != nil && len(work) > 0 {
+		// the worker went away; it gets the work on its next poll
+		a.Manager.ReturnWork(name, work)
+	}
+}
+
+// ClaimContainersHandler answers a restarted worker with the tasks it still
+// runs, out of the containers it reports for tasks it no longer knows
+func (a *Api) ClaimContainersHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	d := json.NewDecoder(r.Body)
+	var containers []worker.Container
+	err := d.Decode(&containers)
+	var claimed []task.Task
+	if err == nil {
+		claimed, err = a.Manager.ClaimContainers(name, containers)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("Error claiming containers: %v\n", err)
+		log.Println(msg)
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(claimed)
 }
@@ -8,9 +8,9 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/docker/go-connections/nat"
 	"github.com/golang-collections/collections/queue"
 	"github.com/google/uuid"
 	"github.com/wtran29/go-orchestrator/node"
@@ -20,8 +20,32 @@ import (
 	"github.com/wtran29/go-orchestrator/worker"
 )
 
-// Manager will keep track of the workers in the cluster
+// ErrWorkerUnreachable is returned when the manager cannot connect to a worker
+var ErrWorkerUnreachable = errors.New("unable to connect to worker")
+
+// ErrWorkerFull is returned when a worker turns a task away for lack of capacity
+var ErrWorkerFull = errors.New("worker has no capacity for task")
+
+// workerLostAfter is how long a worker can be unreachable before the tasks on
+// it are considered lost rather than unknown
+const workerLostAfter = 2 * time.Minute
+
+// taskLostAfter is how long a task can be missing from the tasks its worker
+// reports before it is considered lost. It allows for a task the worker has
+// been sent but not yet stored.
+const taskLostAfter = 2 * time.Minute
+
+// workerTimeout bounds the requests the manager makes to workers
+const workerTimeout = 10 * time.Second
+
+// Manager will keep track of the workers in the cluster. Its loops and API
+// handlers run concurrently: exported methods take mu, which guards all of
+// the manager's state, and unexported ones expect it to be held. No request
+// to a worker or an extender is made while holding mu. The manager decides
+// what to do under mu, releases it to make the requests, and takes it again
+// to record how they went.
 type Manager struct {
+	mu            sync.Mutex
 	Pending       queue.Queue // which tasks will be placed upon first being submitted
 	TaskDb        store.Store
 	EventDb       store.Store
@@ -31,6 +55,16 @@ type Manager struct {
 	LastWorker    int
 	WorkerNodes   []*node.Node
 	Scheduler     scheduler.Scheduler
+	Extenders     []*scheduler.Extender   // consulted by SelectWorker after the scheduler's filters
+	gangs         map[string]*gang        // gang jobs waiting for all of their tasks to be placed
+	gangStarted   map[uuid.UUID]bool      // members of gangs that have started, placed on their own if queued again
+	unreachable   map[string]time.Time    // when each worker that cannot be reached was first found unreachable
+	missing       map[uuid.UUID]time.Time // when each task was first missing from its worker's reports
+	watch         *watchHub               // streams task and node changes to watchers
+	pull          *pullWorkers            // workers that pull their work rather than being sent it
+	stops         []taskStop              // stops decided under mu, sent by unlock once it is released
+	sending       map[uuid.UUID]bool      // tasks being sent to their worker, whose stops wait until they have been
+	schedMu       sync.Mutex              // runs the scheduler for one placement at a time
 }
 
 func New(workers []string, schedulerType string, dbType string) *Manager {
@@ -44,14 +78,9 @@ func New(workers []string, schedulerType string, dbType string) *Manager {
 		n := node.NewNode(workers[worker], nAPI, "worker")
 		nodes = append(nodes, n)
 	}
-	var s scheduler.Scheduler
-	switch schedulerType {
-	case "roundrobin":
-		s = &scheduler.RoundRobin{Name: "roundrobin"}
-	case "epvm":
-		s = &scheduler.Epvm{Name: "epvm"}
-	default:
-
+	s, err := scheduler.New(schedulerType)
+	if err != nil {
+		log.Fatalf("unable to create scheduler: %v", err)
 	}
 	m := Manager{
 		Pending:       *queue.New(),
@@ -60,10 +89,16 @@ func New(workers []string, schedulerType string, dbType string) *Manager {
 		TaskWorkerMap: taskWorkerMap,
 		WorkerNodes:   nodes,
 		Scheduler:     s,
+		gangs:         make(map[string]*gang),
+		gangStarted:   make(map[uuid.UUID]bool),
+		sending:       make(map[uuid.UUID]bool),
+		unreachable:   make(map[string]time.Time),
+		missing:       make(map[uuid.UUID]time.Time),
+		watch:         newWatchHub(),
+		pull:          newPullWorkers(),
 	}
 	var ts store.Store
 	var es store.Store
-	var err error
 	switch dbType {
 	case "memory":
 		ts = store.NewInMemoryTaskStore()
@@ -79,76 +114,280 @@ func New(workers []string, schedulerType string, dbType string) *Manager {
 		log.Fatalf("unable to create event store: %v", err)
 	}
 
-	m.TaskDb = ts
+	m.TaskDb = &watchedTaskStore{Store: ts, hub: m.watch}
 	m.EventDb = es
 	return &m
 }
 
-// SelectWorker uses the Scheduler interface to select a worker
+// SelectWorker uses the Scheduler interface to select a worker. The node
+// returned is a copy.
 func (m *Manager) SelectWorker(t task.Task) (*node.Node, error) {
-	candidates := m.Scheduler.SelectCandidateNodes(t, m.WorkerNodes)
-	if candidates == nil {
-		msg := fmt.Sprintf("No avaiable candidates match resource request for task %v\n", t.ID)
-		err := errors.New(msg)
+	m.mu.Lock()
+	nodes := m.nodeSnapshot()
+	m.unlock()
+	return m.selectWorker(t, nodes)
+}
+
+// selectWorker runs the scheduler and its extenders for t against nodes,
+// copies of the worker nodes taken with nodeSnapshot. It is called without
+// holding mu, so extenders can take their time; schedMu keeps the scheduler,
+// which is not safe for concurrent use, to one placement at a time.
+func (m *Manager) selectWorker(t task.Task, nodes []*node.Node) (*node.Node, error) {
+	m.schedMu.Lock()
+	defer m.schedMu.Unlock()
+	candidates := m.Scheduler.SelectCandidateNodes(t, schedulableNodes(t, nodes))
+	candidates, _, err := scheduler.ApplyExtenders(m.Extenders, t, candidates)
+	if err != nil {
 		return nil, err
 	}
+	if candidates == nil {
+		return nil, fmt.Errorf("no available candidates match resource request for task %v", t.ID)
+	}
 	scores := m.Scheduler.Score(t, candidates)
+	err = scheduler.AddExtenderScores(m.Extenders, t, candidates, scores)
+	if err != nil {
+		return nil, err
+	}
 	selectNode := m.Scheduler.Pick(scores, candidates)
 	return selectNode, nil
 }
 
-// UpdateTasks will track tasks, their states, and machine on which they run
+// nodeSnapshot returns a copy of every worker node, for the scheduler to run
+// on without holding mu
+func (m *Manager) nodeSnapshot() []*node.Node {
+	var nodes []*node.Node
+	for _, n := range m.WorkerNodes {
+		nodes = append(nodes, n.Copy())
+	}
+	return nodes
+}
+
+// ExplainTask runs the scheduler against the task without placing it, reporting
+// why each node was rejected or how it scored
+func (m *Manager) ExplainTask(t task.Task) scheduler.Explanation {
+	m.mu.Lock()
+	nodes := m.nodeSnapshot()
+	m.unlock()
+	m.schedMu.Lock()
+	defer m.schedMu.Unlock()
+	return scheduler.Explain(m.Scheduler, m.Extenders, t, schedulableNodes(t, nodes))
+}
+
+// UpdateTasks will track tasks, their states, and machine on which they run.
+// Workers also push changes as they happen, so the poll mostly catches what
+// a push missed.
 func (m *Manager) updateTasks() {
 	for _, worker := range m.Workers {
-		log.Printf("Checking worker %v for task updates", worker)
-		url := fmt.Sprintf("http://%s/tasks", worker)
-		resp, err := http.Get(url)
-		if err != nil {
-			log.Printf("Error connecting to %v: %v\n", worker, err)
+		if m.pull.active(worker) {
+			// the worker reports its tasks each time it polls for work
 			continue
 		}
-		if resp.StatusCode != http.StatusOK {
-			log.Printf("[manager] Error sending request: %v\n", err)
+		log.Printf("Checking worker %v for task updates", worker)
+		tasks, err := m.fetchTasks(worker)
+		if errors.Is(err, ErrWorkerUnreachable) {
+			log.Printf("[manager] %v\n", err)
+			m.mu.Lock()
+			m.markUnreachable(worker)
+			m.unlock()
 			continue
 		}
-
-		d := json.NewDecoder(resp.Body)
-		var tasks []*task.Task
-		err = d.Decode(&tasks)
+		m.mu.Lock()
+		delete(m.unreachable, worker)
+		var reported []uuid.UUID
+		for _, t := range tasks {
+			m.applyTaskUpdate(worker, t)
+			reported = append(reported, t.ID)
+		}
+		if err == nil {
+			m.checkMissing(worker, reported)
+		}
+		m.unlock()
 		if err != nil {
-			log.Printf("[manager] Error unmarshalling tasks: %s\n", err.Error())
+			log.Printf("[manager] %v\n", err)
+		}
+	}
+}
 
+// fetchTasks asks the worker for the state of its tasks
+func (m *Manager) fetchTasks(worker string) ([]*task.Task, error) {
+	url := fmt.Sprintf("http://%s/tasks", worker)
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v: %v", ErrWorkerUnreachable, worker, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error getting tasks from %v: %v", worker, resp.StatusCode)
+	}
+
+	d := json.NewDecoder(resp.Body)
+	var tasks []*task.Task
+	err = d.Decode(&tasks)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling tasks: %v", err)
+	}
+	return tasks, nil
+}
+
+// ReportTaskStatus records the state of a task pushed by the worker running it
+func (m *Manager) ReportTaskStatus(worker string, t *task.Task) {
+	m.mu.Lock()
+	defer m.unlock()
+	m.applyTaskUpdate(worker, t)
+}
+
+// applyTaskUpdate records the state of a task as reported by a worker, either
+// in answer to the manager's poll or pushed by the worker as it changed
+func (m *Manager) applyTaskUpdate(worker string, t *task.Task) {
+	log.Printf("[manager] Attempting to update task %v\n", t.ID)
+	if m.TaskWorkerMap[t.ID] != worker {
+		// the task has since been evicted or moved to another worker
+		log.Printf("[manager] Ignoring update for task %v from worker %s it is no longer assigned to\n", t.ID, worker)
+		return
+	}
+
+	result, err := m.TaskDb.Get(t.ID.String())
+	if err != nil {
+		log.Printf("[manager] %s", err)
+		return
+	}
+	taskPersisted, ok := result.(*task.Task)
+	if !ok {
+		log.Printf("cannot convert result %v to task.Task type\n", result)
+		return
+	}
+
+	if t.RestartCount < taskPersisted.RestartCount {
+		// reported before the worker picked up the task's restart
+		log.Printf("[manager] Ignoring stale update for task %v from before restart %d\n", t.ID, taskPersisted.RestartCount)
+		return
+	}
+
+	if taskPersisted.State != t.State {
+		prev := taskPersisted.State
+		if !task.ValidStateTransition(prev, t.State) {
+			// e.g. a worker that has not yet processed a stop or restart
+			log.Printf("[manager] Ignoring invalid transition of task %v from %v to %v\n", t.ID, prev, t.State)
+			return
 		}
-		for _, t := range tasks {
-			log.Printf("[manager] Attempting to update task %v\n", t.ID)
-
-			// _, ok := m.TaskDb[t.ID]
-			// if !ok {
-			// 	log.Printf("[manager] Task with ID %s not found\n", t.ID)
-			// 	continue
-			// }
-			result, err := m.TaskDb.Get(t.ID.String())
+		if !prev.Terminal() && t.State.Terminal() {
+			m.releaseTask(taskPersisted)
+		}
+		if prev == task.Stopping {
+			// keep the reason the manager gave for stopping the task
+			taskPersisted.State = t.State
+			m.recordEvent(*taskPersisted, t.Reason, t.StatusMessage)
+		} else if err := m.setState(taskPersisted, t.State, t.Reason, t.StatusMessage); err != nil {
+			log.Printf("[manager] %v\n", err)
+			return
+		}
+		// tasks that were asked to stop are not restarted
+		if !prev.Terminal() && prev != task.Stopping && t.State.Terminal() {
+			m.planRestart(taskPersisted)
+		}
+		// a task without a readiness probe is ready as soon as it runs
+		taskPersisted.Ready = t.State == task.Running && taskPersisted.Readiness == nil
+		if t.State != task.Running {
+			taskPersisted.ReadyStatus = task.ProbeStatus{}
+		}
+	}
+
+	taskPersisted.StartTime = t.StartTime
+	taskPersisted.FinishTime = t.FinishTime
+	taskPersisted.ContainerID = t.ContainerID
+	taskPersisted.HostPorts = t.HostPorts
+
+	m.TaskDb.Put(taskPersisted.ID.String(), taskPersisted)
+}
+
+// markUnreachable records that worker cannot be reached. Its tasks become
+// Unknown, and Lost once the worker has been unreachable for workerLostAfter.
+func (m *Manager) markUnreachable(worker string) {
+	since, ok := m.unreachable[worker]
+	if !ok {
+		since = time.Now()
+		m.unreachable[worker] = since
+	}
+	lost := time.Since(since) >= workerLostAfter
+	for _, id := range m.WorkerTaskMap[worker] {
+		result, err := m.TaskDb.Get(id.String())
+		if err != nil || m.TaskWorkerMap[id] != worker {
+			continue
+		}
+		t := result.(*task.Task)
+		switch {
+		case lost && (t.State == task.Unknown || t.State == task.Stopping):
+			log.Printf("[manager] task %v lost with unreachable worker %s\n", t.ID, worker)
+			prev := t.State
+			err := m.setState(t, task.Lost, task.ReasonWorkerLost, fmt.Sprintf("worker %s has been unreachable since %s", worker, since.Format(time.RFC3339)))
 			if err != nil {
-				log.Printf("[manager] %s", err)
+				log.Printf("[manager] %v\n", err)
 				continue
 			}
-			taskPersisted, ok := result.(*task.Task)
-			if !ok {
-				log.Printf("cannot convert result %v to task.Task type\n", result)
-				continue
+			m.releaseTask(t)
+			t.Ready = false
+			if prev == task.Stopping {
+				m.TaskDb.Put(t.ID.String(), t)
+			} else {
+				m.planRestart(t)
 			}
-
-			if taskPersisted.State != t.State {
-				taskPersisted.State = t.State
+		case t.State == task.Scheduled || t.State == task.Running:
+			// a task being stopped stays Stopping, so it is not restarted if lost
+			err := m.setState(t, task.Unknown, task.ReasonWorkerUnreachable, fmt.Sprintf("unable to reach worker %s", worker))
+			if err != nil {
+				log.Printf("[manager] %v\n", err)
+				continue
 			}
+			t.Ready = false
+			m.TaskDb.Put(t.ID.String(), t)
+		}
+	}
+}
 
-			taskPersisted.StartTime = t.StartTime
-			taskPersisted.FinishTime = t.FinishTime
-			taskPersisted.ContainerID = t.ContainerID
-			taskPersisted.HostPorts = t.HostPorts
-
-			m.TaskDb.Put(taskPersisted.ID.String(), taskPersisted)
-
+// checkMissing marks the tasks assigned to worker that it no longer reports as
+// lost, once they have been missing for taskLostAfter, e.g. because the worker
+// restarted with the memory store and their containers are gone
+func (m *Manager) checkMissing(worker string, reported []uuid.UUID) {
+	seen := make(map[uuid.UUID]bool)
+	for _, id := range reported {
+		seen[id] = true
+	}
+	for _, id := range m.WorkerTaskMap[worker] {
+		if seen[id] || m.TaskWorkerMap[id] != worker {
+			delete(m.missing, id)
+			continue
+		}
+		result, err := m.TaskDb.Get(id.String())
+		if err != nil {
+			continue
+		}
+		t := result.(*task.Task)
+		if t.State.Terminal() || t.State == task.Pending {
+			delete(m.missing, id)
+			continue
+		}
+		since, ok := m.missing[id]
+		if !ok {
+			m.missing[id] = time.Now()
+			continue
+		}
+		if time.Since(since) < taskLostAfter {
+			continue
+		}
+		delete(m.missing, id)
+		log.Printf("[manager] task %v lost, worker %s no longer reports it\n", t.ID, worker)
+		prev := t.State
+		err = m.setState(t, task.Lost, task.ReasonMissingFromWorker, fmt.Sprintf("worker %s has not reported the task since %s", worker, since.Format(time.RFC3339)))
+		if err != nil {
+			log.Printf("[manager] %v\n", err)
+			continue
+		}
+		m.releaseTask(t)
+		t.Ready = false
+		if prev == task.Stopping {
+			m.TaskDb.Put(t.ID.String(), t)
+		} else {
+			m.planRestart(t)
 		}
 	}
 }
@@ -168,94 +407,351 @@ func (m *Manager) ProcessTasks() {
 	for {
 		log.Println("Processing any tasks in the queue")
 		m.SendWork()
+		m.SendGangs()
 		log.Println("Sleeping for 10 seconds")
 		time.Sleep(10 * time.Second)
 	}
 }
 
-// SendWork sends tasks to workers
+// SendWork sends tasks to workers. The task is placed and sent without
+// holding mu, which is only taken to take the task off the queue, to assign
+// it to the selected worker, and to undo that if the worker cannot take it.
 func (m *Manager) SendWork() {
-	if m.Pending.Len() > 0 {
-		e := m.Pending.Dequeue()
-		te := e.(task.TaskEvent)
-		err := m.EventDb.Put(te.ID.String(), &te)
-		if err != nil {
-			log.Printf("error attempting to store task event %s: %s\n", te.ID.String(), err)
-			return
+	m.mu.Lock()
+	te, nodes, ok := m.nextPlacement()
+	m.unlock()
+	if !ok {
+		return
+	}
+
+	w, err := m.selectWorker(te.Task, nodes)
+	m.mu.Lock()
+	if m.stoppedWhilePlaced(te.Task.ID) {
+		m.unlock()
+		return
+	}
+	if err != nil {
+		log.Printf("error selecting worker for task %s: %v", te.Task.ID, err)
+		// keep the task pending so it can be explained and retried later
+		t := te.Task
+		if err := m.setState(&t, task.Pending, task.ReasonUnschedulable, err.Error()); err != nil {
+			log.Printf("[manager] %v\n", err)
 		}
-		log.Printf("Pulled %v off pending queue", te)
+		m.TaskDb.Put(t.ID.String(), &t)
+		te.Task = t
+		m.Pending.Enqueue(te)
+		m.unlock()
+		return
+	}
+	scheduled, err := m.assignTask(te, w.Name)
+	m.unlock()
+	if err != nil {
+		log.Printf("[manager] %v\n", err)
+		return
+	}
+
+	err = m.sendTask(w.Name, scheduled)
+	m.mu.Lock()
+	defer m.unlock()
+	m.recordSend(te, w.Name, err)
+}
+
+// nextPlacement takes the next event off the pending queue. Stops are handled
+// straight away, as are gang members and tasks past their scheduling
+// deadline. A task to be placed on its own is returned, along with the nodes
+// to place it on.
+func (m *Manager) nextPlacement() (task.TaskEvent, []*node.Node, bool) {
+	if m.Pending.Len() == 0 {
+		log.Println("No work in the queue")
+		return task.TaskEvent{}, nil, false
+	}
+	e := m.Pending.Dequeue()
+	te := e.(task.TaskEvent)
+	err := m.EventDb.Put(te.ID.String(), &te)
+	if err != nil {
+		log.Printf("error attempting to store task event %s: %s\n", te.ID.String(), err)
+		return te, nil, false
+	}
+	log.Printf("Pulled %v off pending queue", te)
 
-		taskWorker, ok := m.TaskWorkerMap[te.Task.ID]
-		if ok {
-			result, err := m.TaskDb.Get(te.Task.ID.String())
+	taskWorker, ok := m.TaskWorkerMap[te.Task.ID]
+	if ok {
+		result, err := m.TaskDb.Get(te.Task.ID.String())
+		if err != nil {
+			log.Printf("unable to schedule task: %s\n", err)
+			return te, nil, false
+		}
+		persistedTask, ok := result.(*task.Task)
+		if !ok {
+			log.Println("unable to convert tasks to task.Task type")
+			return te, nil, false
+		}
+		if te.State == task.Completed && persistedTask.State.Terminal() && m.sending[te.Task.ID] {
+			// the task is being restarted, so stop it once the worker has it
+			m.Pending.Enqueue(te)
+			return te, nil, false
+		}
+		if te.State == task.Completed && persistedTask.State.Terminal() {
+			// nothing left to stop, but a planned restart is cancelled
+			persistedTask.NextRetry = time.Time{}
+			m.TaskDb.Put(persistedTask.ID.String(), persistedTask)
+			return te, nil, false
+		}
+		if te.State == task.Completed && task.ValidStateTransition(persistedTask.State, task.Stopping) {
+			err := m.setState(persistedTask, task.Stopping, task.ReasonStopRequested, "stop requested")
 			if err != nil {
-				log.Printf("unable to schedule task: %s\n", err)
-				return
-			}
-			persistedTask, ok := result.(*task.Task)
-			if !ok {
-				log.Println("unable to convert tasks to task.Task type")
-				return
+				log.Printf("[manager] %v\n", err)
+				return te, nil, false
 			}
-			if te.State == task.Completed && task.ValidStateTransition(persistedTask.State, te.State) {
-				m.stopTask(taskWorker, te.Task.ID.String())
-				return
+			persistedTask.NextRetry = time.Time{}
+			m.TaskDb.Put(persistedTask.ID.String(), persistedTask)
+			m.stopTask(taskWorker, te.Task.ID.String())
+			return te, nil, false
+		}
+		log.Printf("invalid request: existing task %s is in state %v and cannot be stopped", persistedTask.ID.String(), persistedTask.State)
+	} else if te.State == task.Completed {
+		// the task has not been placed on a worker, e.g. it is still unschedulable
+		m.cancelPending(te.Task.ID)
+		return te, nil, false
+	}
+
+	if m.failUnschedulable(te.Task) {
+		return te, nil, false
+	}
+	if te.Task.GangSize > 1 && !m.gangStarted[te.Task.ID] {
+		m.addGangMember(te)
+		return te, nil, false
+	}
+	return te, m.nodeSnapshot(), true
+}
+
+// cancelPending stops a task that is not placed on any worker. It is marked
+// Completed, and its events still waiting in the pending queue or its gang
+// are dropped so it is never started.
+func (m *Manager) cancelPending(id uuid.UUID) {
+	n := m.Pending.Len()
+	for i := 0; i < n; i++ {
+		te := m.Pending.Dequeue().(task.TaskEvent)
+		if te.Task.ID != id {
+			m.Pending.Enqueue(te)
+		}
+	}
+	delete(m.gangStarted, id)
+	for job, g := range m.gangs {
+		var remaining []task.TaskEvent
+		for _, te := range g.Members {
+			if te.Task.ID != id {
+				remaining = append(remaining, te)
 			}
-			log.Printf("invalid request: existing task %s is in state %v and cannot transition to the completed state", persistedTask.ID.String(), persistedTask.State)
 		}
+		g.Members = remaining
+		if len(g.Members) == 0 {
+			delete(m.gangs, job)
+		}
+	}
 
-		t := te.Task
-		w, err := m.SelectWorker(t)
+	result, err := m.TaskDb.Get(id.String())
+	if err != nil {
+		log.Printf("[manager] no task %s to stop: %v\n", id, err)
+		return
+	}
+	t := result.(*task.Task)
+	t.NextRetry = time.Time{}
+	if !t.State.Terminal() {
+		log.Printf("[manager] stopping task %s before it was placed on a worker\n", id)
+		err := m.setState(t, task.Completed, task.ReasonStopRequested, "stopped before it was placed on a worker")
 		if err != nil {
-			log.Printf("error selecting worker for task %s: %v", t.ID, err)
-			return
+			log.Printf("[manager] %v\n", err)
+		} else {
+			t.FinishTime = time.Now().UTC()
 		}
+	}
+	m.TaskDb.Put(t.ID.String(), t)
+}
 
-		log.Printf("[manager] selected worker %s for task %s\n", w.Name, t.ID)
+// stoppedWhilePlaced reports whether a task taken off the queue to be placed
+// was stopped before it could be assigned to a worker
+func (m *Manager) stoppedWhilePlaced(id uuid.UUID) bool {
+	result, err := m.TaskDb.Get(id.String())
+	if err != nil {
+		return false
+	}
+	return result.(*task.Task).State.Terminal()
+}
 
-		m.WorkerTaskMap[w.Name] = append(m.WorkerTaskMap[w.Name], te.Task.ID)
-		m.TaskWorkerMap[t.ID] = w.Name
+// assignTask places the task on the named worker and returns the event to
+// send the worker. The task's resources are reserved on the worker's node
+// before it is sent, so they are accounted for while the worker starts it.
+func (m *Manager) assignTask(te task.TaskEvent, name string) (task.TaskEvent, error) {
+	n := m.getNode(name)
+	if n == nil {
+		return te, fmt.Errorf("no node for worker %s", name)
+	}
+	t := te.Task
+	log.Printf("[manager] selected worker %s for task %s\n", name, t.ID)
 
-		t.State = task.Scheduled
-		m.TaskDb.Put(t.ID.String(), &t)
+	err := m.setState(&t, task.Scheduled, task.ReasonScheduled, fmt.Sprintf("scheduled to worker %s", name))
+	if err != nil {
+		return te, err
+	}
+	n.Allocate(t)
+	m.WorkerTaskMap[name] = append(m.WorkerTaskMap[name], t.ID)
+	m.TaskWorkerMap[t.ID] = name
+	m.sending[t.ID] = true
+	m.TaskDb.Put(t.ID.String(), &t)
+	te.Task = t
+	return te, nil
+}
 
-		data, err := json.Marshal(te)
-		if err != nil {
-			log.Printf("Unable to marshal task object: %v\n", t)
+// recordSend records how sending the task to worker w went. te is the event
+// as it was queued. A task that could not be sent is queued to be placed
+// again if the worker could not be reached or had no room for it, and a task
+// stopped or moved off w while it was sent is stopped on w.
+func (m *Manager) recordSend(te task.TaskEvent, w string, err error) {
+	id := te.Task.ID
+	delete(m.sending, id)
+	if m.TaskWorkerMap[id] != w {
+		// the task was moved off the worker while it was sent, e.g. evicted
+		if err == nil {
+			m.stopTask(w, id.String())
 		}
-		url := fmt.Sprintf("http://%s/tasks", w.Name)
-		resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+		return
+	}
+	result, dbErr := m.TaskDb.Get(id.String())
+	if dbErr != nil {
+		log.Printf("[manager] %v\n", dbErr)
+		return
+	}
+	stored := result.(*task.Task)
+	if err == nil {
+		if stored.State == task.Stopping {
+			m.stopTask(w, id.String())
+		}
+		return
+	}
+
+	log.Printf("[manager] %v\n", err)
+	t := te.Task
+	m.releaseTask(&t)
+	m.unassignTask(w, id)
+	if stored.State == task.Stopping {
+		// stopped before the worker took it, so there is nothing to stop
+		m.cancelPending(id)
+		return
+	}
+	switch {
+	case errors.Is(err, ErrWorkerUnreachable):
+		m.Pending.Enqueue(te)
+	case errors.Is(err, ErrWorkerFull):
+		m.requeueRejected(&t, w, err.Error())
+	}
+}
+
+// sendTask hands a task event to the worker: it is left for the worker's
+// next poll if the worker pulls its work, and posted to the worker otherwise
+func (m *Manager) sendTask(name string, te task.TaskEvent) error {
+	if m.pull.active(name) {
+		m.pull.deliver(name, te)
+		return nil
+	}
+	data, err := json.Marshal(te)
+	if err != nil {
+		return fmt.Errorf("unable to marshal task object %v: %v", te.Task, err)
+	}
+	client := &http.Client{Timeout: workerTimeout}
+	url := fmt.Sprintf("http://%s/tasks", name)
+	resp, err := client.Post(url, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return fmt.Errorf("%w: %v: %v", ErrWorkerUnreachable, name, err)
+	}
+	defer resp.Body.Close()
+	d := json.NewDecoder(resp.Body)
+	if resp.StatusCode != http.StatusCreated {
+		e := worker.ErrResponse{}
+		err := d.Decode(&e)
 		if err != nil {
-			log.Printf("Error connecting to %v: %v", w, err)
-			m.Pending.Enqueue(t)
-			return
+			return fmt.Errorf("error decoding response: %v", err)
 		}
-		d := json.NewDecoder(resp.Body)
-		if resp.StatusCode != http.StatusCreated {
-			e := worker.ErrResponse{}
-			err := d.Decode(&e)
-			if err != nil {
-				fmt.Printf("Error decoding response: %s\n", err.Error())
-				return
-			}
-			log.Printf("Response error (%d): %s\n", e.HTTPStatusCode, e.Message)
-			return
+		if e.Code == worker.CodeOverCapacity {
+			return fmt.Errorf("%w: %v: %s", ErrWorkerFull, name, strings.TrimSpace(e.Message))
 		}
-		t = task.Task{}
-		err = d.Decode(&t)
-		if err != nil {
-			fmt.Printf("Error decoding response: %s\n", err.Error())
-			return
+		return fmt.Errorf("response error (%d): %s", e.HTTPStatusCode, e.Message)
+	}
+	t := task.Task{}
+	err = d.Decode(&t)
+	if err != nil {
+		log.Printf("Error decoding response: %s\n", err.Error())
+		return nil
+	}
+	log.Printf("[manager] received response from worker: %#v\n", t)
+	return nil
+}
+
+// isWorker reports whether name is one of the manager's workers
+func (m *Manager) isWorker(name string) bool {
+	for _, w := range m.Workers {
+		if w == name {
+			return true
 		}
-		w.TaskCount++
-		log.Printf("[manager] received response from worker: %#v\n", t)
-	} else {
-		log.Println("No work in the queue")
 	}
+	return false
 }
 
+// getNode returns the node for the named worker
+func (m *Manager) getNode(name string) *node.Node {
+	for _, n := range m.WorkerNodes {
+		if n.Name == name {
+			return n
+		}
+	}
+	return nil
+}
+
+// releaseTask frees the resources allocated on its node to a task that has stopped running
+func (m *Manager) releaseTask(t *task.Task) {
+	n := m.getNode(m.TaskWorkerMap[t.ID])
+	if n == nil {
+		return
+	}
+	n.Release(*t)
+}
+
+// taskStop is a stop decided while holding mu, to be sent to the worker once
+// it is released
+type taskStop struct {
+	worker string
+	taskID string
+}
+
+// unlock releases mu and then sends the stops decided while it was held
+func (m *Manager) unlock() {
+	stops := m.stops
+	m.stops = nil
+	m.mu.Unlock()
+	for _, s := range stops {
+		m.sendStop(s.worker, s.taskID)
+	}
+}
+
+// stopTask asks the worker to stop the task: it is left for the worker's next
+// poll if the worker pulls its work, and sent to the worker by unlock
+// otherwise. The stop of a task still being sent to its worker is left until
+// the worker has it.
 func (m *Manager) stopTask(worker string, taskID string) {
-	client := &http.Client{}
+	if m.sending[uuid.MustParse(taskID)] {
+		return
+	}
+	if m.pull.active(worker) {
+		m.pull.deliver(worker, stopEvent(uuid.MustParse(taskID)))
+		log.Printf("task %s has been scheduled to be stopped", taskID)
+		return
+	}
+	m.stops = append(m.stops, taskStop{worker: worker, taskID: taskID})
+}
+
+// sendStop asks the worker to stop the task
+func (m *Manager) sendStop(worker string, taskID string) {
+	client := &http.Client{Timeout: workerTimeout}
 	url := fmt.Sprintf("http://%s/tasks/%s", worker, taskID)
 	req, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
@@ -267,6 +763,7 @@ func (m *Manager) stopTask(worker string, taskID string) {
 		log.Printf("error connecting to worker at %s: %v", url, err)
 		return
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != 204 {
 		log.Printf("Error sending request: %v", err)
 		return
@@ -276,13 +773,59 @@ func (m *Manager) stopTask(worker string, taskID string) {
 
 // AddTask adds task to the manager's queue of pending tasks
 func (m *Manager) AddTask(te task.TaskEvent) {
+	m.mu.Lock()
+	defer m.unlock()
+	m.addTask(te)
+}
+
+func (m *Manager) addTask(te task.TaskEvent) {
 	log.Printf("Add event %v to pending queue", te)
+	if te.Timestamp.IsZero() {
+		te.Timestamp = time.Now().UTC()
+	}
+	if te.State != task.Completed {
+		// the task waits to be placed, whatever state it was submitted in
+		te.Task.State = task.Pending
+		te.Task.PendingSince = time.Now().UTC()
+	}
 	m.Pending.Enqueue(te)
 }
 
-// GetTasks calls the list method and converts the results from empty
-// interface to a slice of pointers to task.type
+// GetTasks returns a copy of every task, which the caller is free to use
+// while the manager goes on changing its tasks
 func (m *Manager) GetTasks() []*task.Task {
+	m.mu.Lock()
+	defer m.unlock()
+	var tasks []*task.Task
+	for _, t := range m.getTasks() {
+		c := *t
+		tasks = append(tasks, &c)
+	}
+	return tasks
+}
+
+// GetTask returns a copy of the task with the given ID
+func (m *Manager) GetTask(id uuid.UUID) (*task.Task, error) {
+	m.mu.Lock()
+	defer m.unlock()
+	result, err := m.TaskDb.Get(id.String())
+	if err != nil {
+		return nil, err
+	}
+	t := *result.(*task.Task)
+	return &t, nil
+}
+
+// GetNodes returns a copy of every worker node
+func (m *Manager) GetNodes() []*node.Node {
+	m.mu.Lock()
+	defer m.unlock()
+	return m.nodeSnapshot()
+}
+
+// getTasks calls the list method and converts the results from empty
+// interface to a slice of pointers to task.type
+func (m *Manager) getTasks() []*task.Task {
 	taskList, err := m.TaskDb.List()
 	if err != nil {
 		log.Printf("error getting list of tasks: %v", err)
@@ -291,114 +834,180 @@ func (m *Manager) GetTasks() []*task.Task {
 	return taskList.([]*task.Task)
 }
 
-// checkTaskHealth is responsible for calling task's healthcheck url
-func (m *Manager) checkTaskHealth(t task.Task) error {
-	log.Printf("Calling health check for task %s: %s\n", t.ID, t.HealthCheck)
-	w := m.TaskWorkerMap[t.ID]
-	hostPort := getHostPort(t.HostPorts)
-	worker := strings.Split(w, ":")
-	url := fmt.Sprintf("http://%s:%s%s", worker[0], *hostPort, t.HealthCheck)
-	log.Printf("Calling health check for task %s: %s\n", t.ID, url)
-	resp, err := http.Get(url)
-	if err != nil {
-		msg := fmt.Sprintf("Error connecting to health check %s", url)
-		log.Println(msg)
-		return errors.New(msg)
-	}
+// probeRun is a probe that is due on a task. Probes are run without holding
+// the manager's lock, so the task and its worker are captured beforehand.
+type probeRun struct {
+	task     task.Task
+	worker   string
+	probe    *task.Probe
+	liveness bool // the task's HealthCheck rather than its Readiness probe
+	result   task.ProbeResult
+}
 
-	if resp.StatusCode != http.StatusOK {
-		msg := fmt.Sprintf("Error health check for task %s did not return 200\n", t.ID)
-		log.Println(msg)
-		return errors.New(msg)
+// dueProbes returns the probes of running tasks that are due to run. Exec
+// probes are not run on workers that pull their work, as the manager cannot
+// connect to them; that is no fault of the task, so nothing is recorded.
+func (m *Manager) dueProbes() []probeRun {
+	m.mu.Lock()
+	defer m.unlock()
+	var runs []probeRun
+	now := time.Now()
+	for _, t := range m.getTasks() {
+		if t.State != task.Running {
+			continue
+		}
+		w := m.TaskWorkerMap[t.ID]
+		runnable := func(p *task.Probe) bool {
+			return p.Exec == nil || !m.pull.active(w)
+		}
+		if t.Readiness != nil && runnable(t.Readiness) && t.ReadyStatus.Due(t.Readiness, t.StartTime, now) {
+			runs = append(runs, probeRun{task: *t, worker: w, probe: t.Readiness})
+		}
+		if t.HealthCheck != nil && runnable(t.HealthCheck) && t.NextRetry.IsZero() && t.HealthStatus.Due(t.HealthCheck, t.StartTime, now) {
+			runs = append(runs, probeRun{task: *t, worker: w, probe: t.HealthCheck, liveness: true})
+		}
 	}
-	log.Printf("Task %s health check response: %v\n", t.ID, resp.StatusCode)
-	return nil
+	return runs
 }
 
-func getHostPort(ports nat.PortMap) *string {
-	for k := range ports {
-		return &ports[k][0].HostPort
+// recordProbe records the result of a probe on its task, unless the task has
+// since stopped or been restarted
+func (m *Manager) recordProbe(r probeRun) {
+	result, err := m.TaskDb.Get(r.task.ID.String())
+	if err != nil {
+		return
 	}
-	return nil
+	t := result.(*task.Task)
+	if t.State != task.Running || !t.StartTime.Equal(r.task.StartTime) || m.TaskWorkerMap[t.ID] != r.worker {
+		return
+	}
+	if r.liveness {
+		if t.NextRetry.IsZero() && m.recordTaskHealth(t, r.result) {
+			log.Printf("Task %s failed %d consecutive health checks\n", t.ID, t.HealthStatus.ConsecutiveFailures)
+			m.planRestart(t)
+		}
+		return
+	}
+	m.recordTaskReadiness(t, r.result)
 }
 
-// doHealthChecks is responsible for health checks
-func (m *Manager) doHealthChecks() {
-	tasks := m.GetTasks()
-	for _, t := range tasks {
-		if t.State == task.Running && t.RestartCount < 3 {
-			err := m.checkTaskHealth(*t)
-			if err != nil {
-				if t.RestartCount < 3 {
-					m.restartTask(t)
-				}
-			}
-		} else if t.State == task.Failed && t.RestartCount < 3 {
-			m.restartTask(t)
+// recordTaskReadiness records the result of the task's readiness probe. A
+// failing readiness probe only marks the task not ready, taking it out of its
+// service's endpoints; it is never restarted for it.
+func (m *Manager) recordTaskReadiness(t *task.Task, result task.ProbeResult) {
+	wasReady := t.Ready
+	t.RecordReadiness(result)
+	if wasReady != t.Ready {
+		log.Printf("Task %s readiness changed to %t: %s\n", t.ID, t.Ready, result.Message)
+		if t.Ready {
+			m.recordEvent(*t, task.ReasonReady, "readiness probe passed")
+		} else {
+			m.recordEvent(*t, task.ReasonNotReady, result.Message)
 		}
 	}
+	m.TaskDb.Put(t.ID.String(), t)
 }
 
-// restartTasks is responsible for restarting tasks that have failed
-func (m *Manager) restartTask(t *task.Task) {
-	// get worker where the task was running
-	w := m.TaskWorkerMap[t.ID]
-	t.State = task.Scheduled
-	t.RestartCount++
-	// overwrite existing task to ensure it has the current state
+// recordTaskHealth records the result of the task's health check, and returns
+// true if the task should be restarted
+func (m *Manager) recordTaskHealth(t *task.Task, result task.ProbeResult) bool {
+	if result.Success {
+		log.Printf("Task %s health check passed\n", t.ID)
+	} else {
+		log.Printf("Task %s health check failed: %s\n", t.ID, result.Message)
+	}
+	failed := t.HealthStatus.Record(t.HealthCheck, result)
+	if failed {
+		m.recordEvent(*t, task.ReasonHealthCheckFailed, result.Message)
+	}
 	m.TaskDb.Put(t.ID.String(), t)
+	return failed
+}
 
-	te := task.TaskEvent{
-		ID:        uuid.New(),
-		State:     task.Running,
-		Timestamp: time.Now(),
-		Task:      *t,
-	}
-	data, err := json.Marshal(te)
-	if err != nil {
-		log.Printf("Unable to marshal task object: %v.\n", t)
-		return
+// doHealthChecks is responsible for health checks, for stopping tasks past
+// their deadline, and for restarting tasks once the backoff set by their
+// restart policy has passed. Probes and restarts are sent without holding mu.
+func (m *Manager) doHealthChecks() {
+	runs := m.dueProbes()
+	for i := range runs {
+		runs[i].result = m.runProbe(runs[i].task, runs[i].worker, runs[i].probe)
 	}
-	url := fmt.Sprintf("http://%s/tasks", w)
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
-	if err != nil {
-		log.Printf("Error connecting to %v: %v\n", w, err)
-		m.Pending.Enqueue(t)
-		return
+
+	m.mu.Lock()
+	for _, r := range runs {
+		m.recordProbe(r)
 	}
-	d := json.NewDecoder(resp.Body)
-	if resp.StatusCode != http.StatusCreated {
-		e := worker.ErrResponse{}
-		err := d.Decode(&e)
-		if err != nil {
-			fmt.Printf("Error decoding response: %s\n", err.Error())
-			return
+	var restarts []restartRun
+	now := time.Now()
+	for _, t := range m.getTasks() {
+		if t.State == task.Running && t.ActiveDeadlineSeconds > 0 {
+			m.enforceActiveDeadline(t)
+		}
+		restartable := t.State.Terminal() || t.State == task.Running
+		if restartable && !t.NextRetry.IsZero() && !now.Before(t.NextRetry) {
+			if r, ok := m.restartTask(t); ok {
+				restarts = append(restarts, r)
+			}
 		}
-		log.Printf("Response error (%d): %s", e.HTTPStatusCode, e.Message)
+	}
+	m.unlock()
+	if len(restarts) == 0 {
 		return
 	}
-	log.Printf("%#v\n", t)
+
+	for i := range restarts {
+		restarts[i].err = m.sendTask(restarts[i].worker, restarts[i].event)
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	for _, r := range restarts {
+		m.recordRestart(r)
+	}
 }
 
-// DoHealthChecks is a wrapper for the doHealthChecks method
+// DoHealthChecks is a wrapper for the doHealthChecks method. Each task's
+// probe runs on its own period and restarts wait out their own backoff, so
+// tasks are checked every second.
 func (m *Manager) DoHealthChecks() {
 	for {
-		log.Println("Performing task health check")
 		m.doHealthChecks()
-		log.Println("Task health checks completed")
-		log.Println("Sleeping for 60 seconds")
-		time.Sleep(60 * time.Second)
+		time.Sleep(1 * time.Second)
 	}
 }
 
+// UpdateNodeStats keeps the stats cached on each node fresh for the scheduler.
+// Every node is refreshed in its own loop so that a slow or unreachable
+// worker does not hold up the others.
 func (m *Manager) UpdateNodeStats() {
+	var wg sync.WaitGroup
+	for _, n := range m.WorkerNodes {
+		wg.Add(1)
+		go func(n *node.Node) {
+			defer wg.Done()
+			m.updateNodeStats(n)
+		}(n)
+	}
+	wg.Wait()
+}
+
+func (m *Manager) updateNodeStats(n *node.Node) {
 	for {
-		for _, node := range m.WorkerNodes {
-			log.Printf("Collecting stats for node %v", node.Name)
-			_, err := node.GetStats()
-			if err != nil {
-				log.Printf("error updating node stats: %v", err)
-			}
+		if m.pull.active(n.Name) {
+			// the worker sends its stats each time it polls for work
+			time.Sleep(15 * time.Second)
+			continue
+		}
+		log.Printf("Collecting stats for node %v", n.Name)
+		stats, err := n.FetchStats()
+		if err != nil {
+			log.Printf("error updating node stats: %v", err)
+		} else {
+			m.mu.Lock()
+			n.SetStats(*stats)
+			m.watch.publishNode(n)
+			// the worker may have reported new NoExecute taints
+			m.evictTasks(n)
+			m.unlock()
 		}
 		time.Sleep(15 * time.Second)
 	}
@@ -0,0 +1,552 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/disk"
+	"github.com/shirou/gopsutil/load"
+	"github.com/shirou/gopsutil/mem"
+	"github.com/wtran29/go-orchestrator/scheduler"
+	"github.com/wtran29/go-orchestrator/stats"
+	"github.com/wtran29/go-orchestrator/task"
+	"github.com/wtran29/go-orchestrator/worker"
+)
+
+func TestMain(m *testing.M) {
+	// the manager logs every step it takes
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// fakeWorker answers the manager's requests as a worker whose tasks start as
+// soon as they are sent, each in a new container, except for tasks named
+// crash, which fail, and tasks named flaky, which fail until restarted. With
+// rejectEvery set, every rejectEvery-th task is turned away for lack of
+// capacity. onPost and onDelete, if set, are called with each task the worker
+// is sent or asked to stop before it answers.
+type fakeWorker struct {
+	srv         *httptest.Server
+	mu          sync.Mutex
+	tasks       map[uuid.UUID]task.Task
+	hostPort    string // published for every task, so the manager's probes reach it
+	posts       int
+	rejectEvery int
+	onPost      func(t task.Task)
+	onDelete    func(id uuid.UUID)
+}
+
+func newFakeWorker(tb testing.TB, hostPort string) *fakeWorker {
+	fw := &fakeWorker{tasks: make(map[uuid.UUID]task.Task), hostPort: hostPort}
+	fw.srv = httptest.NewServer(http.HandlerFunc(fw.serveHTTP))
+	tb.Cleanup(fw.srv.Close)
+	return fw
+}
+
+// name is the address the manager knows the worker by
+func (fw *fakeWorker) name() string {
+	return fw.srv.Listener.Addr().String()
+}
+
+func (fw *fakeWorker) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/stats":
+		json.NewEncoder(w).Encode(workerStats())
+	case r.Method == http.MethodGet && r.URL.Path == "/tasks":
+		fw.mu.Lock()
+		tasks := []task.Task{}
+		for _, t := range fw.tasks {
+			tasks = append(tasks, t)
+		}
+		fw.mu.Unlock()
+		json.NewEncoder(w).Encode(tasks)
+	case r.Method == http.MethodPost && r.URL.Path == "/tasks":
+		te := task.TaskEvent{}
+		json.NewDecoder(r.Body).Decode(&te)
+		t := te.Task
+		if fw.onPost != nil {
+			fw.onPost(t)
+		}
+		fw.mu.Lock()
+		fw.posts++
+		posts := fw.posts
+		full := fw.rejectEvery > 0 && fw.posts%fw.rejectEvery == 0
+		fw.mu.Unlock()
+		if full {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(worker.ErrResponse{HTTPStatusCode: http.StatusConflict, Message: "no room", Code: worker.CodeOverCapacity})
+			return
+		}
+		t.ContainerID = fmt.Sprintf("container-%d", posts)
+		if t.Name == "crash" || t.Name == "flaky" && t.RestartCount == 0 {
+			t.SetState(task.Failed, task.ReasonError, "exited with status 1")
+		} else {
+			t.SetState(task.Running, task.ReasonStarted, "started")
+		}
+		t.StartTime = time.Now().UTC()
+		if fw.hostPort != "" {
+			t.HostPorts = nat.PortMap{"80/tcp": []nat.PortBinding{{HostPort: fw.hostPort}}}
+		}
+		fw.mu.Lock()
+		fw.tasks[t.ID] = t
+		fw.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(t)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/tasks/"):
+		id, err := uuid.Parse(strings.TrimPrefix(r.URL.Path, "/tasks/"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if fw.onDelete != nil {
+			fw.onDelete(id)
+		}
+		fw.mu.Lock()
+		t, ok := fw.tasks[id]
+		if ok {
+			t.SetState(task.Completed, task.ReasonStopped, "stopped")
+			t.FinishTime = time.Now().UTC()
+			fw.tasks[id] = t
+		}
+		fw.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// workerStats are the stats of a worker with 4 cpus, 4GiB of memory and 100GiB of disk
+func workerStats() stats.Stats {
+	return stats.Stats{
+		MemStats:  &mem.VirtualMemoryStat{Total: 4 << 20, Available: 4 << 20},
+		DiskStats: &disk.UsageStat{Total: 100 << 30, Free: 100 << 30},
+		CpuStats:  make([]cpu.TimesStat, 4),
+		LoadStats: &load.AvgStat{},
+	}
+}
+
+// newTestManager returns a manager of the workers whose nodes already have
+// their stats, so tasks can be placed on them straight away
+func newTestManager(workers ...*fakeWorker) *Manager {
+	var names []string
+	for _, w := range workers {
+		names = append(names, w.name())
+	}
+	m := New(names, "roundrobin", "memory")
+	for _, n := range m.WorkerNodes {
+		n.SetStats(workerStats())
+	}
+	return m
+}
+
+// lockFree fails the test unless f, which takes the manager's lock, returns
+// within a second
+func lockFree(t *testing.T, what string, f func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("%s blocked while a request was in progress", what)
+	}
+}
+
+func newTask(name string) task.TaskEvent {
+	return task.TaskEvent{
+		ID:    uuid.New(),
+		State: task.Scheduled,
+		Task:  task.Task{ID: uuid.New(), Name: name, Image: "nginx"},
+	}
+}
+
+func TestSendWorkReleasesLockWhileSending(t *testing.T) {
+	posted := make(chan struct{})
+	release := make(chan struct{})
+	fw := newFakeWorker(t, "")
+	fw.onPost = func(task.Task) {
+		close(posted)
+		<-release
+	}
+	m := newTestManager(fw)
+	te := newTask("web")
+	m.AddTask(te)
+
+	sent := make(chan struct{})
+	go func() {
+		m.SendWork()
+		close(sent)
+	}()
+	<-posted
+	lockFree(t, "GetTasks", func() {
+		tasks := m.GetTasks()
+		if len(tasks) != 1 || tasks[0].State != task.Scheduled {
+			t.Errorf("tasks = %v, want the task Scheduled while it is sent", tasks)
+		}
+	})
+	lockFree(t, "AddTask", func() { m.AddTask(newTask("db")) })
+	close(release)
+	<-sent
+
+	got, err := m.GetTask(te.Task.ID)
+	if err != nil {
+		t.Fatalf("GetTask: %v", err)
+	}
+	if w := m.TaskWorkerMap[te.Task.ID]; w != fw.name() {
+		t.Errorf("task assigned to %q, want %q", w, fw.name())
+	}
+	if got.State != task.Scheduled {
+		t.Errorf("state = %v, want Scheduled", got.State)
+	}
+}
+
+func TestSelectWorkerReleasesLockWhileExtenderRuns(t *testing.T) {
+	filtering := make(chan struct{})
+	release := make(chan struct{})
+	ext := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		args := scheduler.ExtenderArgs{}
+		json.NewDecoder(r.Body).Decode(&args)
+		if r.URL.Path == "/filter" {
+			close(filtering)
+			<-release
+		}
+		var names []string
+		for _, n := range args.Nodes {
+			names = append(names, n.Name)
+		}
+		json.NewEncoder(w).Encode(scheduler.ExtenderFilterResult{Nodes: names})
+	}))
+	defer ext.Close()
+	fw := newFakeWorker(t, "")
+	m := newTestManager(fw)
+	m.Extenders = []*scheduler.Extender{{URL: ext.URL, Timeout: 5 * time.Second}}
+	m.AddTask(newTask("web"))
+
+	sent := make(chan struct{})
+	go func() {
+		m.SendWork()
+		close(sent)
+	}()
+	<-filtering
+	lockFree(t, "GetNodes", func() { m.GetNodes() })
+	lockFree(t, "TaintNode", func() {
+		m.TaintNode(fw.name(), task.Taint{Key: "team", Value: "ml", Effect: task.PreferNoSchedule})
+	})
+	close(release)
+	<-sent
+
+	if len(m.WorkerTaskMap[fw.name()]) != 1 {
+		t.Errorf("worker has tasks %v, want the task placed once the extender answered", m.WorkerTaskMap[fw.name()])
+	}
+}
+
+func TestStopWhileSendingWaitsForWorker(t *testing.T) {
+	posted := make(chan struct{})
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var requests []string
+	fw := newFakeWorker(t, "")
+	fw.onPost = func(task.Task) {
+		close(posted)
+		<-release
+		mu.Lock()
+		requests = append(requests, "POST")
+		mu.Unlock()
+	}
+	fw.onDelete = func(uuid.UUID) {
+		mu.Lock()
+		requests = append(requests, "DELETE")
+		mu.Unlock()
+	}
+	m := newTestManager(fw)
+	te := newTask("web")
+	m.AddTask(te)
+
+	sent := make(chan struct{})
+	go func() {
+		m.SendWork()
+		close(sent)
+	}()
+	<-posted
+	// stop the task while the worker is still being sent it
+	m.AddTask(stopEvent(te.Task.ID))
+	m.SendWork()
+	mu.Lock()
+	early := len(requests)
+	mu.Unlock()
+	if early != 0 {
+		t.Errorf("worker got %d requests before it took the task, want the stop to wait", early)
+	}
+	close(release)
+	<-sent
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(requests, ",") != "POST,DELETE" {
+		t.Errorf("worker got %v, want the task and then its stop", requests)
+	}
+	got, _ := m.GetTask(te.Task.ID)
+	if got.State != task.Stopping {
+		t.Errorf("state = %v, want Stopping", got.State)
+	}
+}
+
+func TestRestartRunsInNewContainer(t *testing.T) {
+	fw := newFakeWorker(t, "")
+	m := newTestManager(fw)
+	te := newTask("flaky")
+	te.Task.Restart = task.Restart{Policy: task.RestartAlways}
+	m.AddTask(te)
+	m.SendWork()
+	m.updateTasks()
+	got, _ := m.GetTask(te.Task.ID)
+	if got.State != task.Failed {
+		t.Fatalf("state = %v, want Failed after the first start", got.State)
+	}
+	first := got.ContainerID
+
+	// skip the restart's backoff
+	m.mu.Lock()
+	result, _ := m.TaskDb.Get(te.Task.ID.String())
+	stored := result.(*task.Task)
+	if stored.NextRetry.IsZero() {
+		t.Fatal("failed task was not planned to restart")
+	}
+	stored.NextRetry = time.Now().UTC()
+	m.TaskDb.Put(stored.ID.String(), stored)
+	m.unlock()
+	m.doHealthChecks()
+	m.updateTasks()
+
+	got, _ = m.GetTask(te.Task.ID)
+	if got.State != task.Running || got.RestartCount != 1 {
+		t.Errorf("state = %v after %d restarts, want Running after 1", got.State, got.RestartCount)
+	}
+	if got.ContainerID == first || got.ContainerID == "" {
+		t.Errorf("container = %q, want the restart's container rather than %q", got.ContainerID, first)
+	}
+	if !got.NextRetry.IsZero() {
+		t.Errorf("running task is due to restart again at %v", got.NextRetry)
+	}
+}
+
+func TestEvictedGangMemberIsPlacedAlone(t *testing.T) {
+	a, b := newFakeWorker(t, ""), newFakeWorker(t, "")
+	m := newTestManager(a, b)
+	var members []task.TaskEvent
+	for i := 0; i < 2; i++ {
+		te := newTask(fmt.Sprintf("train-%d", i))
+		te.Task.Job = "train"
+		te.Task.GangSize = 2
+		members = append(members, te)
+		m.AddTask(te)
+		m.SendWork()
+	}
+	m.SendGangs()
+	tainted := m.TaskWorkerMap[members[0].Task.ID]
+	if tainted == "" {
+		t.Fatal("gang was not started")
+	}
+
+	err := m.TaintNode(tainted, task.Taint{Key: "maintenance", Effect: task.NoExecute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for m.Pending.Len() > 0 {
+		m.SendWork()
+	}
+
+	if len(m.gangs) != 0 {
+		t.Errorf("gangs = %v, want the evicted members placed without waiting for a new gang", m.gangs)
+	}
+	for _, te := range members {
+		got, _ := m.GetTask(te.Task.ID)
+		w := m.TaskWorkerMap[te.Task.ID]
+		if got.State != task.Scheduled && got.State != task.Running || w == "" || w == tainted {
+			t.Errorf("task %s is %v on %q, want it placed off the tainted worker %s", got.Name, got.State, w, tainted)
+		}
+	}
+}
+
+// TestManagerConcurrentRace runs the manager's loops against fake workers
+// while its API is called, for go test -race to find unguarded state
+func TestManagerConcurrentRace(t *testing.T) {
+	var healthy sync.Mutex
+	failing := false
+	probed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		healthy.Lock()
+		defer healthy.Unlock()
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		failing = !failing
+	}))
+	defer probed.Close()
+	_, port, _ := strings.Cut(probed.Listener.Addr().String(), ":")
+	w1 := newFakeWorker(t, port)
+	w2 := newFakeWorker(t, port)
+	w2.rejectEvery = 4
+	workers := []string{w1.name(), w2.name()}
+	m := newTestManager(w1, w2)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	loop := func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				f()
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+	loop(m.SendWork)
+	loop(m.SendGangs)
+	loop(m.updateTasks)
+	loop(m.doHealthChecks)
+	go m.UpdateNodeStats()
+
+	var idsMu sync.Mutex
+	var ids []uuid.UUID
+	randomID := func(r *rand.Rand) (uuid.UUID, bool) {
+		idsMu.Lock()
+		defer idsMu.Unlock()
+		if len(ids) == 0 {
+			return uuid.UUID{}, false
+		}
+		return ids[r.Intn(len(ids))], true
+	}
+	probe := &task.Probe{HTTP: &task.HTTPProbe{Path: "/"}, PeriodSeconds: 1}
+	restart := task.Restart{Policy: task.RestartAlways, MaxAttempts: -1, BackoffSeconds: 1, RescheduleAfter: 2}
+	calls := []func(r *rand.Rand){
+		func(r *rand.Rand) {
+			te := newTask("web")
+			if r.Intn(2) == 0 {
+				te.Task.Name = "crash"
+			}
+			te.Task.HealthCheck = probe
+			te.Task.Readiness = probe
+			te.Task.Restart = restart
+			idsMu.Lock()
+			ids = append(ids, te.Task.ID)
+			idsMu.Unlock()
+			m.AddTask(te)
+		},
+		func(r *rand.Rand) {
+			job := uuid.NewString()
+			for i := 0; i < 2; i++ {
+				te := newTask(job)
+				te.Task.Job = job
+				te.Task.GangSize = 2
+				m.AddTask(te)
+			}
+		},
+		func(r *rand.Rand) {
+			if id, ok := randomID(r); ok {
+				m.AddTask(stopEvent(id))
+			}
+		},
+		func(r *rand.Rand) {
+			id, ok := randomID(r)
+			if !ok {
+				return
+			}
+			state := task.Running
+			if r.Intn(2) == 0 {
+				state = task.Failed
+			}
+			m.ReportTaskStatus(workers[r.Intn(len(workers))], &task.Task{ID: id, State: state})
+		},
+		func(r *rand.Rand) {
+			if r.Intn(20) != 0 {
+				return
+			}
+			taint := task.Taint{Key: "maintenance", Value: "true", Effect: task.NoExecute}
+			w := workers[r.Intn(len(workers))]
+			m.TaintNode(w, taint)
+			m.UntaintNode(w, taint.Key, taint.Effect)
+		},
+		func(r *rand.Rand) { m.GetTasks() },
+		func(r *rand.Rand) { m.GetNodes() },
+		func(r *rand.Rand) { m.ExplainTask(newTask("web").Task) },
+		func(r *rand.Rand) { m.GetEvents(EventFilter{}) },
+		func(r *rand.Rand) { m.Endpoints("web") },
+		func(r *rand.Rand) {
+			if id, ok := randomID(r); ok {
+				m.GetTask(id)
+			}
+		},
+	}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				calls[r.Intn(len(calls))](r)
+				time.Sleep(5 * time.Millisecond)
+			}
+		}(int64(i))
+	}
+
+	time.Sleep(3 * time.Second)
+	close(done)
+	wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.sending) != 0 {
+		t.Errorf("%d tasks still being sent after every request returned", len(m.sending))
+	}
+	if len(m.stops) != 0 {
+		t.Errorf("%d stops left unsent", len(m.stops))
+	}
+	for id, w := range m.TaskWorkerMap {
+		if !containsID(m.WorkerTaskMap[w], id) {
+			t.Errorf("task %s is assigned to %s, which does not list it", id, w)
+		}
+	}
+	for w, tasks := range m.WorkerTaskMap {
+		for _, id := range tasks {
+			if m.TaskWorkerMap[id] != w {
+				t.Errorf("worker %s lists task %s, which is assigned to %q", w, id, m.TaskWorkerMap[id])
+			}
+		}
+	}
+}
+
+func containsID(ids []uuid.UUID, id uuid.UUID) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/wtran29/go-orchestrator/task"
+)
+
+// runProbe runs a single probe against the task and reports the outcome
+func (m *Manager) runProbe(t task.Task, worker string, p *task.Probe) task.ProbeResult {
+	var err error
+	switch {
+	case p.HTTP != nil:
+		err = m.httpProbe(t, worker, p.HTTP, p.Timeout())
+	case p.TCP != nil:
+		err = m.tcpProbe(t, worker, p.TCP, p.Timeout())
+	case p.Exec != nil:
+		return m.execProbe(t, worker, p)
+	default:
+		err = fmt.Errorf("probe has no HTTP, TCP or Exec check")
+	}
+	result := task.ProbeResult{Time: time.Now().UTC(), Success: err == nil}
+	if err != nil {
+		result.Message = err.Error()
+	}
+	return result
+}
+
+// taskAddress returns the host and port on which the task's container port is
+// published on worker w. The first published port is used if port is empty.
+func (m *Manager) taskAddress(t task.Task, w string, port string) (string, error) {
+	if w == "" {
+		return "", fmt.Errorf("task %s is not assigned to a worker", t.ID)
+	}
+	host := strings.Split(w, ":")[0]
+
+	var bindings []nat.PortBinding
+	if port != "" {
+		bindings = t.HostPorts[nat.Port(port)]
+	} else {
+		for _, b := range t.HostPorts {
+			if len(b) > 0 {
+				bindings = b
+				break
+			}
+		}
+	}
+	if len(bindings) == 0 {
+		return "", fmt.Errorf("task %s has no published host port for %q", t.ID, port)
+	}
+	return net.JoinHostPort(host, bindings[0].HostPort), nil
+}
+
+func (m *Manager) httpProbe(t task.Task, w string, p *task.HTTPProbe, timeout time.Duration) error {
+	addr, err := m.taskAddress(t, w, p.Port)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("http://%s%s", addr, p.Path)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("error creating health check request %s: %v", url, err)
+	}
+	for k, v := range p.Headers {
+		req.Header.Set(k, v)
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error connecting to health check %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	expected := p.ExpectedStatus
+	if expected == 0 {
+		expected = http.StatusOK
+	}
+	if resp.StatusCode != expected {
+		return fmt.Errorf("health check %s returned %d, expected %d", url, resp.StatusCode, expected)
+	}
+	for k, v := range p.ExpectedHeaders {
+		if got := resp.Header.Get(k); got != v {
+			return fmt.Errorf("health check %s returned header %s=%q, expected %q", url, k, got, v)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) tcpProbe(t task.Task, w string, p *task.TCPProbe, timeout time.Duration) error {
+	addr, err := m.taskAddress(t, w, p.Port)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %v", addr, err)
+	}
+	conn.Close()
+	return nil
+}
+
+// execProbe asks the task's worker to run the probe's command inside the container
+func (m *Manager) execProbe(t task.Task, w string, p *task.Probe) task.ProbeResult {
+	result := task.ProbeResult{Time: time.Now().UTC()}
+	if w == "" {
+		result.Message = fmt.Sprintf("task %s is not assigned to a worker", t.ID)
+		return result
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		result.Message = fmt.Sprintf("unable to marshal probe: %v", err)
+		return result
+	}
+	// allow for the worker's round trip on top of the command's own timeout
+	client := &http.Client{Timeout: p.Timeout() + 5*time.Second}
+	url := fmt.Sprintf("http://%s/tasks/%s/probe", w, t.ID)
+	resp, err := client.Post(url, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		result.Message = fmt.Sprintf("error connecting to %s: %v", url, err)
+		return result
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		result.Message = fmt.Sprintf("worker returned %d for exec probe", resp.StatusCode)
+		return result
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		result.Message = fmt.Sprintf("error decoding exec probe result: %v", err)
+	}
+	return result
+}
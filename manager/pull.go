@@ -0,0 +1,205 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wtran29/go-orchestrator/task"
+	"github.com/wtran29/go-orchestrator/worker"
+)
+
+const (
+	// pollTimeout is how long a worker's poll is held open waiting for work
+	pollTimeout = 10 * time.Second
+	// pullGrace is how long after its last poll a worker is still treated as
+	// pulling its work. After that the manager dials it again, and finds it
+	// unreachable if it is behind NAT.
+	pullGrace = 15 * time.Second
+)
+
+// mailbox holds the work waiting for a worker that pulls it from the manager
+type mailbox struct {
+	work     []task.TaskEvent
+	wake     chan struct{} // signalled when work is added
+	polling  int           // polls currently waiting for work
+	lastPoll time.Time
+}
+
+// pullWorkers tracks the workers that pull their work rather than being
+// sent it, keyed by worker name
+type pullWorkers struct {
+	mu        sync.Mutex
+	mailboxes map[string]*mailbox
+}
+
+func newPullWorkers() *pullWorkers {
+	return &pullWorkers{mailboxes: make(map[string]*mailbox)}
+}
+
+// active reports whether the worker is currently pulling its work
+func (p *pullWorkers) active(worker string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	mb, ok := p.mailboxes[worker]
+	if !ok {
+		return false
+	}
+	return mb.polling > 0 || time.Since(mb.lastPoll) < pullGrace
+}
+
+// deliver leaves te in the worker's mailbox for its next poll
+func (p *pullWorkers) deliver(worker string, te task.TaskEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	mb, ok := p.mailboxes[worker]
+	if !ok {
+		return
+	}
+	mb.work = append(mb.work, te)
+	select {
+	case mb.wake <- struct{}{}:
+	default:
+	}
+}
+
+// wait returns the work waiting for the worker, waiting up to pollTimeout for
+// some to arrive
+func (p *pullWorkers) wait(ctx context.Context, worker string) []task.TaskEvent {
+	p.mu.Lock()
+	mb, ok := p.mailboxes[worker]
+	if !ok {
+		mb = &mailbox{wake: make(chan struct{}, 1)}
+		p.mailboxes[worker] = mb
+	}
+	mb.polling++
+	mb.lastPoll = time.Now()
+	work := mb.work
+	mb.work = nil
+	p.mu.Unlock()
+
+	if len(work) == 0 {
+		select {
+		case <-mb.wake:
+		case <-ctx.Done():
+		case <-time.After(pollTimeout):
+		}
+		p.mu.Lock()
+		if ctx.Err() == nil {
+			work = mb.work
+			mb.work = nil
+		}
+		p.mu.Unlock()
+	}
+
+	p.mu.Lock()
+	mb.polling--
+	mb.lastPoll = time.Now()
+	p.mu.Unlock()
+	return work
+}
+
+// requeue puts work that could not be handed to the worker back in its mailbox
+func (p *pullWorkers) requeue(worker string, work []task.TaskEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if mb, ok := p.mailboxes[worker]; ok {
+		mb.work = append(work, mb.work...)
+	}
+}
+
+// Poll is called by a worker that pulls its work from the manager. The
+// worker reports its stats and the state of its tasks, and is handed the
+// tasks to start or stop, waiting a while for some if there are none.
+func (m *Manager) Poll(ctx context.Context, name string, req worker.PollRequest) ([]task.TaskEvent, error) {
+	if !m.isWorker(name) {
+		return nil, fmt.Errorf("unknown worker %q", name)
+	}
+	m.mu.Lock()
+	delete(m.unreachable, name)
+	if n := m.getNode(name); n != nil && req.Stats != nil {
+		n.SetStats(*req.Stats)
+		m.watch.publishNode(n)
+		m.evictTasks(n)
+	}
+	var reported []uuid.UUID
+	for i := range req.Tasks {
+		m.applyTaskUpdate(name, &req.Tasks[i])
+		reported = append(reported, req.Tasks[i].ID)
+	}
+	m.checkMissing(name, reported)
+	for _, r := range req.Rejected {
+		m.rejectedByWorker(name, r)
+	}
+	m.unlock()
+	// wait for work without holding the lock, or nothing could queue any
+	return m.pull.wait(ctx, name), nil
+}
+
+// rejectedByWorker moves a task that a pull worker had no room for to
+// another node
+func (m *Manager) rejectedByWorker(name string, r worker.RejectedTask) {
+	if m.TaskWorkerMap[r.TaskID] != name {
+		return
+	}
+	result, err := m.TaskDb.Get(r.TaskID.String())
+	if err != nil {
+		log.Printf("[manager] %v\n", err)
+		return
+	}
+	t := result.(*task.Task)
+	log.Printf("[manager] worker %s rejected task %s: %s\n", name, t.ID, r.Message)
+	m.releaseTask(t)
+	m.unassignTask(name, t.ID)
+	m.requeueRejected(t, name, r.Message)
+}
+
+// ReturnWork puts work that could not be sent back to a polling worker back
+// in its mailbox for the next poll
+func (m *Manager) ReturnWork(name string, work []task.TaskEvent) {
+	log.Printf("[manager] returning %d undelivered events to worker %s\n", len(work), name)
+	m.pull.requeue(name, work)
+}
+
+// ClaimContainers is told by a restarted worker about containers it created
+// for tasks it no longer knows. It returns the tasks that are still assigned
+// to the worker, which the worker adopts; it removes the other containers.
+func (m *Manager) ClaimContainers(name string, containers []worker.Container) ([]task.Task, error) {
+	if !m.isWorker(name) {
+		return nil, fmt.Errorf("unknown worker %q", name)
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	claimed := []task.Task{}
+	for _, c := range containers {
+		if m.TaskWorkerMap[c.TaskID] != name {
+			log.Printf("[manager] task %s of container %s on worker %s is not assigned to it\n", c.TaskID, c.ContainerID, name)
+			continue
+		}
+		result, err := m.TaskDb.Get(c.TaskID.String())
+		if err != nil {
+			log.Printf("[manager] %v\n", err)
+			continue
+		}
+		t := result.(*task.Task)
+		if t.State.Terminal() {
+			continue
+		}
+		log.Printf("[manager] worker %s still runs task %s in container %s\n", name, t.ID, c.ContainerID)
+		claimed = append(claimed, *t)
+	}
+	return claimed, nil
+}
+
+// stopEvent asks a worker that pulls its work to stop the task
+func stopEvent(taskID uuid.UUID) task.TaskEvent {
+	return task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Completed,
+		Timestamp: time.Now().UTC(),
+		Task:      task.Task{ID: taskID},
+	}
+}
@@ -0,0 +1,230 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wtran29/go-orchestrator/node"
+	"github.com/wtran29/go-orchestrator/task"
+)
+
+// planRestart decides, from its restart policy, whether a task that stopped,
+// was lost or failed its health check is restarted, and sets its NextRetry
+// after the policy's backoff if so
+func (m *Manager) planRestart(t *task.Task) {
+	defer m.TaskDb.Put(t.ID.String(), t)
+	// a running task is only restarted because it failed its health check
+	failed := t.State == task.Failed || t.State == task.Lost || t.State == task.Running
+	if failed {
+		t.NodeFailures++
+	} else {
+		t.NodeFailures = 0
+	}
+	state := t.State
+	if state != task.Completed {
+		state = task.Failed
+	}
+	if !t.Restart.Restarts(state) {
+		log.Printf("[manager] restart policy %q does not restart task %s in state %v\n", t.Restart.Policy, t.ID, state)
+		return
+	}
+	if !t.Restart.AttemptsLeft(t.RestartCount) {
+		log.Printf("[manager] task %s has used all of its %d restarts\n", t.ID, t.RestartCount)
+		return
+	}
+	t.NextRetry = time.Now().UTC().Add(t.Restart.Backoff(t.RestartCount))
+	log.Printf("[manager] restarting task %s at %v\n", t.ID, t.NextRetry)
+	m.recordEvent(*t, task.ReasonBackOff, fmt.Sprintf("restarting at %s", t.NextRetry.Format(time.RFC3339)))
+}
+
+// restartRun is a restart decided while holding mu, to be sent to the worker
+// once it is released
+type restartRun struct {
+	worker    string
+	event     task.TaskEvent
+	allocated bool // whether resources were reserved for the restart on the worker's node
+	err       error
+}
+
+// restartTask restarts a task whose NextRetry has passed. The task is started
+// again on the same worker unless it was lost with its worker or has failed
+// there RescheduleAfter times in a row, in which case it is scheduled onto
+// another node. A restart on the same worker is returned to be sent without
+// holding mu, and recorded with recordRestart.
+func (m *Manager) restartTask(t *task.Task) (restartRun, bool) {
+	w := m.TaskWorkerMap[t.ID]
+	t.NextRetry = time.Time{}
+	_, workerGone := m.unreachable[w]
+	repeated := t.Restart.RescheduleAfter > 0 && t.NodeFailures >= t.Restart.RescheduleAfter
+	if (workerGone || repeated) && len(m.WorkerNodes) > 1 {
+		m.rescheduleTask(t, w)
+		return restartRun{}, false
+	}
+
+	restarted := *t
+	restarted.RestartCount++
+	restarted.SetState(task.Scheduled, task.ReasonRestarted, fmt.Sprintf("restart %d on worker %s", restarted.RestartCount, w))
+	restarted.ResetProbes()
+	r := restartRun{
+		worker: w,
+		event: task.TaskEvent{
+			ID:        uuid.New(),
+			State:     task.Running,
+			Timestamp: time.Now(),
+			Task:      restarted,
+		},
+	}
+	// reserve the task's resources while the worker starts it
+	if t.State.Terminal() {
+		if n := m.getNode(w); n != nil {
+			n.Allocate(*t)
+			r.allocated = true
+		}
+	}
+	m.sending[t.ID] = true
+	// with NextRetry cleared the task is not restarted again while this one is sent
+	m.TaskDb.Put(t.ID.String(), t)
+	return r, true
+}
+
+// recordRestart records how a restart returned by restartTask went. The task
+// only moves to Scheduled once the worker has taken it, so a restart the
+// worker could not be sent is tried again.
+func (m *Manager) recordRestart(r restartRun) {
+	id := r.event.Task.ID
+	delete(m.sending, id)
+	result, err := m.TaskDb.Get(id.String())
+	if err != nil {
+		log.Printf("[manager] %v\n", err)
+		return
+	}
+	t := result.(*task.Task)
+	if m.TaskWorkerMap[id] != r.worker || t.State == task.Stopping {
+		// the task was stopped or moved off the worker while it was restarted
+		if r.err == nil {
+			m.stopTask(r.worker, id.String())
+		}
+		if n := m.getNode(r.worker); n != nil && r.allocated {
+			n.Release(*t)
+		}
+		return
+	}
+
+	if errors.Is(r.err, ErrWorkerFull) {
+		log.Printf("[manager] %v\n", r.err)
+		if r.allocated || !t.State.Terminal() {
+			m.releaseTask(t)
+		}
+		m.unassignTask(r.worker, id)
+		m.requeueRejected(t, r.worker, r.err.Error())
+		return
+	}
+	if r.err != nil {
+		log.Printf("[manager] %v\n", r.err)
+		if r.allocated {
+			m.releaseTask(t)
+		}
+		// try again once the backoff for the next attempt has passed
+		t.NextRetry = time.Now().UTC().Add(t.Restart.Backoff(r.event.Task.RestartCount))
+		m.TaskDb.Put(t.ID.String(), t)
+		return
+	}
+
+	if !r.allocated && t.State.Terminal() {
+		// the task stopped, and was released, while it was restarted
+		if n := m.getNode(r.worker); n != nil {
+			n.Allocate(*t)
+		}
+	}
+	t.RestartCount = r.event.Task.RestartCount
+	if err := m.setState(t, task.Scheduled, task.ReasonRestarted, r.event.Task.StatusMessage); err != nil {
+		log.Printf("[manager] %v\n", err)
+	}
+	t.ResetProbes()
+	// overwrite existing task to ensure it has the current state
+	m.TaskDb.Put(t.ID.String(), t)
+	log.Printf("%#v\n", t)
+}
+
+// rescheduleTask moves a task off worker w, where it keeps failing or which
+// cannot be reached, and
+// queues it to be scheduled onto another node
+func (m *Manager) rescheduleTask(t *task.Task, w string) {
+	log.Printf("[manager] task %s failed %d times in a row on %s, moving it to another node\n", t.ID, t.NodeFailures, w)
+	running := !t.State.Terminal()
+	err := m.setState(t, task.Pending, task.ReasonRescheduled, fmt.Sprintf("moved off worker %s after %d failures", w, t.NodeFailures))
+	if err != nil {
+		log.Printf("[manager] %v\n", err)
+		return
+	}
+	if running {
+		m.stopTask(w, t.ID.String())
+		m.releaseTask(t)
+	}
+	m.unassignTask(w, t.ID)
+
+	t.RestartCount++
+	t.AvoidNode = w
+	t.NodeFailures = 0
+	t.ResetProbes()
+	m.TaskDb.Put(t.ID.String(), t)
+	m.addTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Scheduled,
+		Timestamp: time.Now(),
+		Task:      *t,
+	})
+}
+
+// requeueRejected queues a task that worker w turned away for lack of
+// capacity to be placed on another node. The task must already be released
+// and unassigned from w.
+func (m *Manager) requeueRejected(t *task.Task, w string, message string) {
+	err := m.setState(t, task.Pending, task.ReasonRejected, message)
+	if err != nil {
+		log.Printf("[manager] %v\n", err)
+		return
+	}
+	t.AvoidNode = w
+	m.TaskDb.Put(t.ID.String(), t)
+	m.addTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Scheduled,
+		Timestamp: time.Now(),
+		Task:      *t,
+	})
+}
+
+// schedulableNodes returns the nodes the scheduler may place t on, leaving out
+// the node it was moved off after failing there, unless it is the only node
+func schedulableNodes(t task.Task, nodes []*node.Node) []*node.Node {
+	if t.AvoidNode == "" {
+		return nodes
+	}
+	var schedulable []*node.Node
+	for _, n := range nodes {
+		if n.Name != t.AvoidNode {
+			schedulable = append(schedulable, n)
+		}
+	}
+	if len(schedulable) == 0 {
+		return nodes
+	}
+	return schedulable
+}
+
+// unassignTask forgets that task id was placed on worker w, so that reports
+// about it from w are ignored
+func (m *Manager) unassignTask(w string, id uuid.UUID) {
+	var remaining []uuid.UUID
+	for _, tid := range m.WorkerTaskMap[w] {
+		if tid != id {
+			remaining = append(remaining, tid)
+		}
+	}
+	m.WorkerTaskMap[w] = remaining
+	delete(m.TaskWorkerMap, id)
+}
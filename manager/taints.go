@@ -0,0 +1,105 @@
+package manager
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wtran29/go-orchestrator/node"
+	"github.com/wtran29/go-orchestrator/task"
+)
+
+// TaintNode sets a taint on the named node. Tasks already on the node that do
+// not tolerate a NoExecute taint are evicted.
+func (m *Manager) TaintNode(name string, taint task.Taint) error {
+	if err := taint.Validate(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	n := m.getNode(name)
+	if n == nil {
+		return fmt.Errorf("node %s not found", name)
+	}
+	n.AddTaint(taint)
+	log.Printf("[manager] tainted node %s with %s\n", name, taint)
+	m.watch.publishNode(n)
+	m.evictTasks(n)
+	return nil
+}
+
+// UntaintNode removes the taints with key from the named node. An empty
+// effect removes the key's taints for every effect.
+func (m *Manager) UntaintNode(name string, key string, effect string) error {
+	m.mu.Lock()
+	defer m.unlock()
+	n := m.getNode(name)
+	if n == nil {
+		return fmt.Errorf("node %s not found", name)
+	}
+	n.RemoveTaint(key, effect)
+	log.Printf("[manager] removed taint %s from node %s\n", key, name)
+	m.watch.publishNode(n)
+	return nil
+}
+
+// untoleratedNoExecute returns the first NoExecute taint on n that t does not tolerate
+func untoleratedNoExecute(t *task.Task, n *node.Node) *task.Taint {
+	for _, taint := range n.AllTaints() {
+		if taint.Effect == task.NoExecute && !t.ToleratesTaint(taint) {
+			return &taint
+		}
+	}
+	return nil
+}
+
+// evictTasks stops the tasks on n that do not tolerate its NoExecute taints
+// and queues them to be scheduled onto another node
+func (m *Manager) evictTasks(n *node.Node) {
+	var remaining []uuid.UUID
+	for _, id := range m.WorkerTaskMap[n.Name] {
+		result, err := m.TaskDb.Get(id.String())
+		if err != nil {
+			continue
+		}
+		t := result.(*task.Task)
+		if m.TaskWorkerMap[id] != n.Name {
+			continue
+		}
+		if t.State.Terminal() || t.State == task.Pending || t.State == task.Stopping {
+			// nothing to evict, but the task is still assigned to the worker
+			remaining = append(remaining, id)
+			continue
+		}
+		taint := untoleratedNoExecute(t, n)
+		if taint == nil {
+			remaining = append(remaining, id)
+			continue
+		}
+
+		log.Printf("[manager] evicting task %s from node %s: untolerated taint %s\n", id, n.Name, taint)
+		err = m.setState(t, task.Pending, task.ReasonEvicted, fmt.Sprintf("evicted from node %s: untolerated taint %s", n.Name, taint))
+		if err != nil {
+			log.Printf("[manager] %v\n", err)
+			remaining = append(remaining, id)
+			continue
+		}
+		m.stopTask(n.Name, id.String())
+		n.Release(*t)
+		// reports from the old worker are ignored from now on
+		delete(m.TaskWorkerMap, id)
+
+		m.TaskDb.Put(t.ID.String(), t)
+		evicted := *t
+		evicted.ContainerID = ""
+		evicted.HostPorts = nil
+		m.addTask(task.TaskEvent{
+			ID:        uuid.New(),
+			State:     task.Scheduled,
+			Timestamp: time.Now(),
+			Task:      evicted,
+		})
+	}
+	m.WorkerTaskMap[n.Name] = remaining
+}
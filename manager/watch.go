@@ -0,0 +1,164 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/wtran29/go-orchestrator/node"
+	"github.com/wtran29/go-orchestrator/store"
+	"github.com/wtran29/go-orchestrator/task"
+)
+
+const (
+	// watchHistory is how many changes are kept for watchers resuming from a revision
+	watchHistory = 1000
+	// watchBuffer is how many changes a watcher can fall behind before it is dropped
+	watchBuffer = 100
+)
+
+// ErrRevisionTooOld is returned when a watcher resumes from a revision that
+// is no longer in the change history. It has to start again from the
+// current state.
+var ErrRevisionTooOld = errors.New("revision is too old to resume from")
+
+// WatchEvent is a change to a task or node streamed to watchers. Revisions
+// increase by one with every change.
+type WatchEvent struct {
+	Revision uint64
+	Type     string     // "task" or "node"
+	Task     *task.Task `json:",omitempty"`
+	Node     *node.Node `json:",omitempty"`
+}
+
+// watchHub fans changes out to watchers and keeps a short history of them
+type watchHub struct {
+	mu       sync.Mutex
+	revision uint64
+	history  []WatchEvent
+	last     map[string][]byte // last published encoding of each task and node, to skip writes that change nothing
+	watchers map[chan WatchEvent]struct{}
+}
+
+func newWatchHub() *watchHub {
+	return &watchHub{
+		last:     make(map[string][]byte),
+		watchers: make(map[chan WatchEvent]struct{}),
+	}
+}
+
+func (h *watchHub) publishTask(t task.Task) {
+	h.publish(WatchEvent{Type: "task", Task: &t}, "task/"+t.ID.String(), t)
+}
+
+func (h *watchHub) publishNode(n *node.Node) {
+	// copy the node, which the manager keeps changing, as it is now
+	nc := n.Copy()
+	h.publish(WatchEvent{Type: "node", Node: nc}, "node/"+n.Name, nc)
+}
+
+func (h *watchHub) publish(e WatchEvent, key string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("[manager] unable to encode %s for watchers: %v\n", key, err)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if bytes.Equal(h.last[key], data) {
+		return
+	}
+	h.last[key] = data
+
+	h.revision++
+	e.Revision = h.revision
+	h.history = append(h.history, e)
+	if len(h.history) > watchHistory {
+		h.history = h.history[len(h.history)-watchHistory:]
+	}
+	for ch := range h.watchers {
+		select {
+		case ch <- e:
+		default:
+			// the watcher fell too far behind; it can resume from its last revision
+			delete(h.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe registers a watcher. It returns the changes after revision that
+// the watcher missed, or the current revision if revision is 0, along with
+// the channel further changes are sent on.
+func (h *watchHub) subscribe(revision uint64) ([]WatchEvent, uint64, chan WatchEvent, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var missed []WatchEvent
+	if revision > h.revision {
+		// e.g. from before the manager restarted
+		return nil, 0, nil, ErrRevisionTooOld
+	}
+	if revision > 0 && revision < h.revision {
+		if len(h.history) == 0 || h.history[0].Revision > revision+1 {
+			return nil, 0, nil, ErrRevisionTooOld
+		}
+		for _, e := range h.history {
+			if e.Revision > revision {
+				missed = append(missed, e)
+			}
+		}
+	}
+	ch := make(chan WatchEvent, watchBuffer)
+	h.watchers[ch] = struct{}{}
+	return missed, h.revision, ch, nil
+}
+
+func (h *watchHub) unsubscribe(ch chan WatchEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.watchers[ch]; ok {
+		delete(h.watchers, ch)
+		close(ch)
+	}
+}
+
+// watchedTaskStore publishes every task written to the store to watchers
+type watchedTaskStore struct {
+	store.Store
+	hub *watchHub
+}
+
+func (s *watchedTaskStore) Put(key string, value interface{}) error {
+	err := s.Store.Put(key, value)
+	if err != nil {
+		return err
+	}
+	if t, ok := value.(*task.Task); ok {
+		s.hub.publishTask(*t)
+	}
+	return nil
+}
+
+// Watch subscribes to task and node changes after revision. With revision 0
+// the watcher is first sent the current state of every task and node. The
+// returned function stops the watch.
+func (m *Manager) Watch(revision uint64) ([]WatchEvent, <-chan WatchEvent, func(), error) {
+	m.mu.Lock()
+	defer m.unlock()
+	missed, current, ch, err := m.watch.subscribe(revision)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if revision == 0 {
+		for _, t := range m.getTasks() {
+			c := *t
+			missed = append(missed, WatchEvent{Revision: current, Type: "task", Task: &c})
+		}
+		for _, n := range m.WorkerNodes {
+			missed = append(missed, WatchEvent{Revision: current, Type: "node", Node: n.Copy()})
+		}
+	}
+	return missed, ch, func() { m.watch.unsubscribe(ch) }, nil
+}
@@ -7,8 +7,10 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/wtran29/go-orchestrator/stats"
+	"github.com/wtran29/go-orchestrator/task"
 	"github.com/wtran29/go-orchestrator/utils"
 )
 
@@ -19,6 +21,7 @@ type Node struct {
 	Ip              string
 	Api             string
 	Cores           int
+	CpuAllocated    float64
 	Memory          int64
 	MemoryAllocated int64
 	Disk            int64
@@ -26,6 +29,12 @@ type Node struct {
 	Role            string
 	TaskCount       int
 	Stats           stats.Stats
+	CpuUsage        float64           // fraction of cpu busy between the last two stats samples
+	StatsUpdated    time.Time         // when Stats was last refreshed from the worker
+	Labels          map[string]string // reported by the worker, e.g. zone=us-east-1a
+	Taints          []task.Taint      // set through the manager, repel tasks that do not tolerate them
+	JobCounts       map[string]int    // number of tasks of each job placed on the node
+	PortsAllocated  map[string]bool   // host ports bound by tasks placed on the node
 }
 
 func NewNode(name string, api string, role string) *Node {
@@ -33,6 +42,17 @@ func NewNode(name string, api string, role string) *Node {
 }
 
 func (n *Node) GetStats() (*stats.Stats, error) {
+	stats, err := n.FetchStats()
+	if err != nil {
+		return nil, err
+	}
+	n.SetStats(*stats)
+	return &n.Stats, nil
+}
+
+// FetchStats gets the stats of the node's worker without recording them on
+// the node
+func (n *Node) FetchStats() (*stats.Stats, error) {
 	var resp *http.Response
 	var err error
 	url := fmt.Sprintf("%s/stats", n.Api)
@@ -56,8 +76,105 @@ func (n *Node) GetStats() (*stats.Stats, error) {
 		log.Println(msg)
 		return nil, errors.New(msg)
 	}
+	return &stats, nil
+}
+
+// SetStats records stats reported by the node's worker, e.g. in answer to
+// GetStats or sent by a worker that pulls its work from the manager
+func (n *Node) SetStats(stats stats.Stats) {
+	n.Cores = len(stats.CpuStats)
 	n.Memory = int64(stats.MemTotalKb())
 	n.Disk = int64(stats.DiskTotal())
+	n.Labels = stats.Labels
+	if n.Stats.CpuStats != nil {
+		n.CpuUsage = stats.CpuUsageSince(&n.Stats)
+	}
 	n.Stats = stats
-	return &n.Stats, nil
+	n.StatsUpdated = time.Now()
+}
+
+// AllTaints returns the taints set on the node through the manager along
+// with those reported by its worker
+func (n *Node) AllTaints() []task.Taint {
+	taints := append([]task.Taint{}, n.Taints...)
+	return append(taints, n.Stats.Taints...)
+}
+
+// AddTaint sets a taint on the node, replacing any taint with the same key and effect
+func (n *Node) AddTaint(taint task.Taint) {
+	n.RemoveTaint(taint.Key, taint.Effect)
+	n.Taints = append(n.Taints, taint)
+}
+
+// RemoveTaint removes the node's taints with the given key. An empty effect
+// removes the key's taints for every effect.
+func (n *Node) RemoveTaint(key string, effect string) {
+	var taints []task.Taint
+	for _, t := range n.Taints {
+		if t.Key == key && (effect == "" || t.Effect == effect) {
+			continue
+		}
+		taints = append(taints, t)
+	}
+	n.Taints = taints
+}
+
+// Copy returns a copy of the node that shares none of the maps and slices
+// that change as tasks are placed on the node and taints are set on it
+func (n *Node) Copy() *Node {
+	c := *n
+	c.Taints = append([]task.Taint(nil), n.Taints...)
+	if n.JobCounts != nil {
+		c.JobCounts = make(map[string]int, len(n.JobCounts))
+		for job, count := range n.JobCounts {
+			c.JobCounts[job] = count
+		}
+	}
+	if n.PortsAllocated != nil {
+		c.PortsAllocated = make(map[string]bool, len(n.PortsAllocated))
+		for port, allocated := range n.PortsAllocated {
+			c.PortsAllocated[port] = allocated
+		}
+	}
+	return &c
+}
+
+// StatsFresh reports whether the node's stats were refreshed within maxAge
+func (n *Node) StatsFresh(maxAge time.Duration) bool {
+	return !n.StatsUpdated.IsZero() && time.Since(n.StatsUpdated) <= maxAge
+}
+
+// Allocate reserves the node's resources for a task that has been placed on it
+func (n *Node) Allocate(t task.Task) {
+	if n.JobCounts == nil {
+		n.JobCounts = make(map[string]int)
+	}
+	if n.PortsAllocated == nil {
+		n.PortsAllocated = make(map[string]bool)
+	}
+	n.CpuAllocated += t.Cpu
+	n.MemoryAllocated += t.Memory
+	n.DiskAllocated += t.Disk
+	for _, hostPort := range t.PortBindings {
+		n.PortsAllocated[hostPort] = true
+	}
+	n.JobCounts[t.JobName()]++
+	n.TaskCount++
+}
+
+// Release frees the resources reserved for a task that is no longer running on the node
+func (n *Node) Release(t task.Task) {
+	n.CpuAllocated = max(n.CpuAllocated-t.Cpu, 0)
+	n.MemoryAllocated = max(n.MemoryAllocated-t.Memory, 0)
+	n.DiskAllocated = max(n.DiskAllocated-t.Disk, 0)
+	for _, hostPort := range t.PortBindings {
+		delete(n.PortsAllocated, hostPort)
+	}
+	job := t.JobName()
+	if n.JobCounts[job] <= 1 {
+		delete(n.JobCounts, job)
+	} else {
+		n.JobCounts[job]--
+	}
+	n.TaskCount = max(n.TaskCount-1, 0)
 }
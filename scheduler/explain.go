@@ -0,0 +1,114 @@
+package scheduler
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/google/uuid"
+	"github.com/wtran29/go-orchestrator/node"
+	"github.com/wtran29/go-orchestrator/task"
+)
+
+// FilterLister is implemented by schedulers whose candidate selection is made
+// up of filter plugins, allowing Explain to report each filter's decision
+type FilterLister interface {
+	FilterPlugins() []FilterPlugin
+}
+
+// FilterResult is a filter plugin's reason for rejecting a node
+type FilterResult struct {
+	Filter string
+	Reason string
+}
+
+// NodeExplanation describes how the scheduler treated a single node
+type NodeExplanation struct {
+	Node     string
+	Rejected []FilterResult // empty if the node is a candidate
+	Score    *float64       // nil if the node was not a candidate or could not be scored
+	Selected bool
+}
+
+// Explanation is the outcome of a scheduling decision without the task being placed
+type Explanation struct {
+	TaskID    uuid.UUID
+	Scheduler string
+	Selected  string // name of the chosen node, empty if no node was chosen
+	Error     string // set if an extender failed
+	Nodes     []NodeExplanation
+}
+
+// Explain runs the scheduler's selection for t against nodes, consulting
+// extenders as SelectWorker would, and reports for every node which filters
+// rejected it and how it scored. Nothing is placed and scheduler state (such
+// as RoundRobin's last worker) is left untouched.
+func Explain(s Scheduler, extenders []*Extender, t task.Task, nodes []*node.Node) Explanation {
+	if rr, ok := s.(*RoundRobin); ok {
+		rrCopy := *rr
+		s = &rrCopy
+	}
+
+	name := schedulerName(s)
+	exp := Explanation{TaskID: t.ID, Scheduler: name}
+	candidates := s.SelectCandidateNodes(t, nodes)
+	candidates, extRejected, err := ApplyExtenders(extenders, t, candidates)
+	if err != nil {
+		exp.Error = err.Error()
+	}
+	isCandidate := make(map[string]bool)
+	for _, n := range candidates {
+		isCandidate[n.Name] = true
+	}
+
+	var scores map[string]float64
+	var selected *node.Node
+	if candidates != nil {
+		scores = s.Score(t, candidates)
+		err = AddExtenderScores(extenders, t, candidates, scores)
+		if err != nil {
+			exp.Error = err.Error()
+		} else {
+			selected = s.Pick(scores, candidates)
+		}
+	}
+	if selected != nil {
+		exp.Selected = selected.Name
+	}
+
+	fl, hasFilters := s.(FilterLister)
+	for _, n := range nodes {
+		ne := NodeExplanation{Node: n.Name, Selected: selected != nil && n.Name == selected.Name}
+		if hasFilters {
+			for _, f := range fl.FilterPlugins() {
+				if err := f.Filter(t, n, nodes); err != nil {
+					ne.Rejected = append(ne.Rejected, FilterResult{Filter: f.Name(), Reason: err.Error()})
+				}
+			}
+		} else if !isCandidate[n.Name] && extRejected[n.Name] == nil {
+			ne.Rejected = append(ne.Rejected, FilterResult{Filter: name, Reason: "not selected as a candidate by the scheduler"})
+		}
+		for url, reason := range extRejected[n.Name] {
+			ne.Rejected = append(ne.Rejected, FilterResult{Filter: "extender " + url, Reason: reason})
+		}
+		if score, ok := scores[n.Name]; ok && isCandidate[n.Name] && !math.IsInf(score, 0) && !math.IsNaN(score) {
+			ne.Score = &score
+		}
+		exp.Nodes = append(exp.Nodes, ne)
+	}
+	return exp
+}
+
+// schedulerName returns the name of one of the built-in schedulers, or its type otherwise
+func schedulerName(s Scheduler) string {
+	switch s := s.(type) {
+	case *RoundRobin:
+		return s.Name
+	case *Epvm:
+		return s.Name
+	case *Pipeline:
+		return s.Name
+	case *TopologySpread:
+		return s.Name
+	}
+	return fmt.Sprintf("%T", s)
+}
@@ -0,0 +1,167 @@
+package scheduler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/wtran29/go-orchestrator/node"
+	"github.com/wtran29/go-orchestrator/task"
+)
+
+// Extender is an external HTTP service consulted during scheduling, allowing
+// site-specific placement logic (licenses, data locality) without writing a
+// new Scheduler. The manager POSTs ExtenderArgs to URL/filter, which responds
+// with an ExtenderFilterResult, and to URL/score, which responds with an
+// ExtenderScoreResult. As with Scheduler.Score, lower scores are better.
+type Extender struct {
+	URL       string
+	Weight    float64       // multiplies the extender's scores; 0 disables scoring
+	Timeout   time.Duration // per request; 5 seconds if zero
+	Ignorable bool          // if true, scheduling continues without the extender when it fails
+}
+
+// ExtenderArgs is the body of a request to an extender
+type ExtenderArgs struct {
+	Task  task.Task
+	Nodes []*node.Node
+}
+
+// ExtenderFilterResult is an extender's response to a filter request
+type ExtenderFilterResult struct {
+	Nodes       []string          // names of the nodes that can run the task
+	FailedNodes map[string]string // reason each rejected node cannot run the task
+	Error       string
+}
+
+// ExtenderScoreResult is an extender's response to a score request
+type ExtenderScoreResult struct {
+	Scores map[string]float64 // score for each node, nodes left out score 0
+	Error  string
+}
+
+// Filter returns the nodes the extender accepts, along with the reason it
+// gave for each node it rejected
+func (e *Extender) Filter(t task.Task, nodes []*node.Node) ([]*node.Node, map[string]string, error) {
+	var result ExtenderFilterResult
+	err := e.post("filter", ExtenderArgs{Task: t, Nodes: nodes}, &result)
+	if err == nil && result.Error != "" {
+		err = fmt.Errorf("extender %s: %s", e.URL, result.Error)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	accepted := make(map[string]bool)
+	for _, name := range result.Nodes {
+		accepted[name] = true
+	}
+	failed := make(map[string]string)
+	var candidates []*node.Node
+	for _, n := range nodes {
+		if accepted[n.Name] {
+			candidates = append(candidates, n)
+			continue
+		}
+		reason, ok := result.FailedNodes[n.Name]
+		if !ok {
+			reason = "rejected by extender"
+		}
+		failed[n.Name] = reason
+	}
+	return candidates, failed, nil
+}
+
+// Score returns the extender's scores for the nodes, multiplied by its weight
+func (e *Extender) Score(t task.Task, nodes []*node.Node) (map[string]float64, error) {
+	var result ExtenderScoreResult
+	err := e.post("score", ExtenderArgs{Task: t, Nodes: nodes}, &result)
+	if err == nil && result.Error != "" {
+		err = fmt.Errorf("extender %s: %s", e.URL, result.Error)
+	}
+	if err != nil {
+		return nil, err
+	}
+	scores := make(map[string]float64)
+	for _, n := range nodes {
+		scores[n.Name] = e.Weight * result.Scores[n.Name]
+	}
+	return scores, nil
+}
+
+func (e *Extender) post(verb string, args ExtenderArgs, result interface{}) error {
+	timeout := e.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	data, err := json.Marshal(args)
+	if err != nil {
+		return fmt.Errorf("unable to marshal extender args: %v", err)
+	}
+	client := &http.Client{Timeout: timeout}
+	url := fmt.Sprintf("%s/%s", strings.TrimSuffix(e.URL, "/"), verb)
+	resp, err := client.Post(url, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return fmt.Errorf("error connecting to extender %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("extender %s returned %d", url, resp.StatusCode)
+	}
+	err = json.NewDecoder(resp.Body).Decode(result)
+	if err != nil {
+		return fmt.Errorf("error decoding response from extender %s: %v", url, err)
+	}
+	return nil
+}
+
+// ApplyExtenders narrows candidates down to the nodes every extender accepts.
+// It returns the reasons nodes were rejected, keyed by node and then by
+// extender URL. A failing extender is skipped if it is ignorable and
+// otherwise fails the whole selection.
+func ApplyExtenders(extenders []*Extender, t task.Task, candidates []*node.Node) ([]*node.Node, map[string]map[string]string, error) {
+	rejected := make(map[string]map[string]string)
+	for _, e := range extenders {
+		if len(candidates) == 0 {
+			break
+		}
+		accepted, failed, err := e.Filter(t, candidates)
+		if err != nil {
+			if e.Ignorable {
+				continue
+			}
+			return nil, rejected, err
+		}
+		for name, reason := range failed {
+			if rejected[name] == nil {
+				rejected[name] = make(map[string]string)
+			}
+			rejected[name][e.URL] = reason
+		}
+		candidates = accepted
+	}
+	return candidates, rejected, nil
+}
+
+// AddExtenderScores adds the weighted scores of every extender to scores
+func AddExtenderScores(extenders []*Extender, t task.Task, candidates []*node.Node, scores map[string]float64) error {
+	for _, e := range extenders {
+		if e.Weight == 0 {
+			continue
+		}
+		extScores, err := e.Score(t, candidates)
+		if err != nil {
+			if e.Ignorable {
+				continue
+			}
+			return err
+		}
+		for name, score := range extScores {
+			scores[name] += score
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wtran29/go-orchestrator/node"
+	"github.com/wtran29/go-orchestrator/task"
+)
+
+func testNodes(names ...string) []*node.Node {
+	var nodes []*node.Node
+	for _, name := range names {
+		nodes = append(nodes, node.NewNode(name, "http://"+name, "worker"))
+	}
+	return nodes
+}
+
+func nodeNames(nodes []*node.Node) []string {
+	var names []string
+	for _, n := range nodes {
+		names = append(names, n.Name)
+	}
+	return names
+}
+
+// extenderServer answers the extender's requests to path with result,
+// failing the test if the request is not a valid ExtenderArgs for t
+func extenderServer(tb testing.TB, t task.Task, path string, result interface{}) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != path {
+			tb.Errorf("got %s %s, want POST %s", r.Method, r.URL.Path, path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var args ExtenderArgs
+		if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+			tb.Errorf("decoding extender args: %v", err)
+		}
+		if args.Task.ID != t.ID {
+			tb.Errorf("got task %s, want %s", args.Task.ID, t.ID)
+		}
+		json.NewEncoder(w).Encode(result)
+	}))
+	tb.Cleanup(srv.Close)
+	return srv
+}
+
+func TestExtenderFilter(t *testing.T) {
+	tk := task.Task{ID: uuid.New(), Name: "db"}
+	srv := extenderServer(t, tk, "/filter", ExtenderFilterResult{
+		Nodes:       []string{"n1", "n3"},
+		FailedNodes: map[string]string{"n2": "no license"},
+	})
+	e := &Extender{URL: srv.URL + "/"}
+
+	candidates, failed, err := e.Filter(tk, testNodes("n1", "n2", "n3", "n4"))
+	if err != nil {
+		t.Fatalf("Filter: %v", err)
+	}
+	if got := strings.Join(nodeNames(candidates), ","); got != "n1,n3" {
+		t.Errorf("candidates = %s, want n1,n3", got)
+	}
+	want := map[string]string{"n2": "no license", "n4": "rejected by extender"}
+	if len(failed) != len(want) {
+		t.Errorf("failed = %v, want %v", failed, want)
+	}
+	for name, reason := range want {
+		if failed[name] != reason {
+			t.Errorf("failed[%s] = %q, want %q", name, failed[name], reason)
+		}
+	}
+}
+
+func TestExtenderScore(t *testing.T) {
+	tk := task.Task{ID: uuid.New(), Name: "db"}
+	srv := extenderServer(t, tk, "/score", ExtenderScoreResult{
+		Scores: map[string]float64{"n1": 1.5, "n2": -1},
+	})
+	e := &Extender{URL: srv.URL, Weight: 2}
+
+	scores, err := e.Score(tk, testNodes("n1", "n2", "n3"))
+	if err != nil {
+		t.Fatalf("Score: %v", err)
+	}
+	want := map[string]float64{"n1": 3, "n2": -2, "n3": 0}
+	for name, score := range want {
+		if scores[name] != score {
+			t.Errorf("scores[%s] = %v, want %v", name, scores[name], score)
+		}
+	}
+}
+
+func TestExtenderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		wantErr string
+	}{
+		{
+			name: "error status",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			wantErr: "returned 500",
+		},
+		{
+			name: "error in result",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"Error":"license server down"}`))
+			},
+			wantErr: "license server down",
+		},
+		{
+			name: "invalid response",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`not json`))
+			},
+			wantErr: "error decoding response",
+		},
+		{
+			name: "timeout",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				// the request is only cancelled once its body has been read
+				io.Copy(io.Discard, r.Body)
+				select {
+				case <-r.Context().Done():
+				case <-time.After(5 * time.Second):
+				}
+			},
+			wantErr: "error connecting to extender",
+		},
+	}
+	tk := task.Task{ID: uuid.New()}
+	nodes := testNodes("n1", "n2")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+			e := &Extender{URL: srv.URL, Weight: 1, Timeout: 50 * time.Millisecond}
+
+			_, _, err := e.Filter(tk, nodes)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Filter error = %v, want one containing %q", err, tt.wantErr)
+			}
+			_, err = e.Score(tk, nodes)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Score error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyExtenders(t *testing.T) {
+	tk := task.Task{ID: uuid.New()}
+	accept := extenderServer(t, tk, "/filter", ExtenderFilterResult{
+		Nodes:       []string{"n1"},
+		FailedNodes: map[string]string{"n2": "too far from the data"},
+	})
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer broken.Close()
+
+	extenders := []*Extender{{URL: broken.URL, Ignorable: true}, {URL: accept.URL}}
+	candidates, rejected, err := ApplyExtenders(extenders, tk, testNodes("n1", "n2"))
+	if err != nil {
+		t.Fatalf("ApplyExtenders: %v", err)
+	}
+	if got := strings.Join(nodeNames(candidates), ","); got != "n1" {
+		t.Errorf("candidates = %s, want n1", got)
+	}
+	if reason := rejected["n2"][accept.URL]; reason != "too far from the data" {
+		t.Errorf("rejected[n2] = %v, want the reason given by %s", rejected["n2"], accept.URL)
+	}
+
+	extenders[0].Ignorable = false
+	_, _, err = ApplyExtenders(extenders, tk, testNodes("n1", "n2"))
+	if err == nil {
+		t.Error("ApplyExtenders succeeded with a failing extender that is not ignorable")
+	}
+}
+
+func TestAddExtenderScores(t *testing.T) {
+	tk := task.Task{ID: uuid.New()}
+	srv := extenderServer(t, tk, "/score", ExtenderScoreResult{Scores: map[string]float64{"n1": 1}})
+	unused := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("extender with weight 0 was asked for scores")
+	}))
+	defer unused.Close()
+
+	scores := map[string]float64{"n1": 0.5, "n2": 0.25}
+	extenders := []*Extender{{URL: srv.URL, Weight: 0.5}, {URL: unused.URL}}
+	err := AddExtenderScores(extenders, tk, testNodes("n1", "n2"), scores)
+	if err != nil {
+		t.Fatalf("AddExtenderScores: %v", err)
+	}
+	if scores["n1"] != 1 || scores["n2"] != 0.25 {
+		t.Errorf("scores = %v, want n1=1 n2=0.25", scores)
+	}
+}
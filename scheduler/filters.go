@@ -0,0 +1,116 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/wtran29/go-orchestrator/node"
+	"github.com/wtran29/go-orchestrator/task"
+)
+
+// ResourcesFilter rejects nodes without enough unallocated cpu, memory and disk for the task
+type ResourcesFilter struct{}
+
+func (ResourcesFilter) Name() string { return "resources" }
+
+func (ResourcesFilter) Filter(t task.Task, n *node.Node, nodes []*node.Node) error {
+	if !checkCpu(t, float64(n.Cores)-n.CpuAllocated) {
+		return fmt.Errorf("insufficient cpu: requested %.2f, available %.2f", t.Cpu, float64(n.Cores)-n.CpuAllocated)
+	}
+	if !checkMemory(t, n.Memory-n.MemoryAllocated) {
+		return fmt.Errorf("insufficient memory: requested %d, available %d", t.Memory, n.Memory-n.MemoryAllocated)
+	}
+	if !checkDisk(t, n.Disk-n.DiskAllocated) {
+		return fmt.Errorf("insufficient disk: requested %d, available %d", t.Disk, n.Disk-n.DiskAllocated)
+	}
+	return nil
+}
+
+func checkCpu(t task.Task, cpuAvailable float64) bool {
+	return t.Cpu <= cpuAvailable
+}
+
+func checkMemory(t task.Task, memoryAvailable int64) bool {
+	return t.Memory <= memoryAvailable
+}
+
+// filterNodes returns the nodes that pass every filter
+func filterNodes(t task.Task, nodes []*node.Node, filters ...FilterPlugin) []*node.Node {
+	var candidates []*node.Node
+	for _, n := range nodes {
+		passed := true
+		for _, f := range filters {
+			if f.Filter(t, n, nodes) != nil {
+				passed = false
+				break
+			}
+		}
+		if passed {
+			candidates = append(candidates, n)
+		}
+	}
+	return candidates
+}
+
+// StatsFilter rejects nodes whose stats have not been refreshed within MaxAge,
+// as their capacity is unknown and the worker may be unreachable
+type StatsFilter struct {
+	MaxAge time.Duration
+}
+
+func (StatsFilter) Name() string { return "stats" }
+
+func (f StatsFilter) Filter(t task.Task, n *node.Node, nodes []*node.Node) error {
+	if n.StatsUpdated.IsZero() {
+		return fmt.Errorf("no stats received from node yet")
+	}
+	if !n.StatsFresh(f.MaxAge) {
+		return fmt.Errorf("stats are stale, last updated %s ago", time.Since(n.StatsUpdated).Round(time.Second))
+	}
+	return nil
+}
+
+// PortsFilter rejects nodes where a host port the task binds is already in use
+type PortsFilter struct{}
+
+func (PortsFilter) Name() string { return "ports" }
+
+func (PortsFilter) Filter(t task.Task, n *node.Node, nodes []*node.Node) error {
+	for _, hostPort := range t.PortBindings {
+		if n.PortsAllocated[hostPort] {
+			return fmt.Errorf("host port %s already allocated", hostPort)
+		}
+	}
+	return nil
+}
+
+// LabelsFilter rejects nodes that do not have every label in the task's NodeSelector
+type LabelsFilter struct{}
+
+func (LabelsFilter) Name() string { return "labels" }
+
+func (LabelsFilter) Filter(t task.Task, n *node.Node, nodes []*node.Node) error {
+	for k, v := range t.NodeSelector {
+		if n.Labels[k] != v {
+			return fmt.Errorf("node label %s=%q does not match selector %s=%q", k, n.Labels[k], k, v)
+		}
+	}
+	return nil
+}
+
+// TaintsFilter rejects nodes with a NoSchedule or NoExecute taint the task does not tolerate
+type TaintsFilter struct{}
+
+func (TaintsFilter) Name() string { return "taints" }
+
+func (TaintsFilter) Filter(t task.Task, n *node.Node, nodes []*node.Node) error {
+	for _, taint := range n.AllTaints() {
+		if taint.Effect == task.PreferNoSchedule {
+			continue
+		}
+		if !t.ToleratesTaint(taint) {
+			return fmt.Errorf("untolerated taint %s", taint)
+		}
+	}
+	return nil
+}
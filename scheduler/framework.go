@@ -0,0 +1,88 @@
+package scheduler
+
+import (
+	"math"
+
+	"github.com/wtran29/go-orchestrator/node"
+	"github.com/wtran29/go-orchestrator/task"
+)
+
+// FilterPlugin decides whether a node is able to run a task at all
+type FilterPlugin interface {
+	Name() string
+	// Filter returns an error describing why n cannot run t, or nil if it can.
+	// nodes is the full set of nodes being considered, for plugins that need
+	// a view of the cluster.
+	Filter(t task.Task, n *node.Node, nodes []*node.Node) error
+}
+
+// ScorePlugin rates how well suited each node is to run a task. As with
+// Scheduler.Score, lower scores are better.
+type ScorePlugin interface {
+	Name() string
+	Score(t task.Task, nodes []*node.Node) map[string]float64
+}
+
+// WeightedScore is a score plugin along with how much it counts towards a
+// node's final score
+type WeightedScore struct {
+	Plugin ScorePlugin
+	Weight float64
+}
+
+// Pipeline is a Scheduler composed of plugins. A node is a candidate if it
+// passes every filter, and its score is the weighted sum of the scores given
+// by each score plugin.
+type Pipeline struct {
+	Name    string
+	Filters []FilterPlugin
+	Scorers []WeightedScore
+}
+
+func (p *Pipeline) FilterPlugins() []FilterPlugin {
+	return p.Filters
+}
+
+func (p *Pipeline) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+	return filterNodes(t, nodes, p.Filters...)
+}
+
+func (p *Pipeline) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	nodeScores := make(map[string]float64)
+	for _, n := range nodes {
+		nodeScores[n.Name] = 0
+	}
+	for _, ws := range p.Scorers {
+		if ws.Weight == 0 {
+			continue
+		}
+		scores := ws.Plugin.Score(t, nodes)
+		for _, n := range nodes {
+			score, ok := scores[n.Name]
+			if !ok {
+				// a plugin that could not score a node makes it the worst choice
+				score = math.Inf(1)
+			}
+			nodeScores[n.Name] += ws.Weight * score
+		}
+	}
+	return nodeScores
+}
+
+func (p *Pipeline) Pick(scores map[string]float64, candidates []*node.Node) *node.Node {
+	return pickLowest(scores, candidates)
+}
+
+// pickLowest returns the candidate with the lowest score, preferring earlier
+// candidates when scores are tied
+func pickLowest(scores map[string]float64, candidates []*node.Node) *node.Node {
+	var bestNode *node.Node
+	var lowestScore float64
+	for idx, node := range candidates {
+		if idx == 0 || scores[node.Name] < lowestScore {
+			bestNode = node
+			lowestScore = scores[node.Name]
+		}
+	}
+	return bestNode
+}
@@ -0,0 +1,126 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Profile describes a Pipeline: the filter plugins a node must pass and the
+// score plugins, with their weights, used to rank the nodes that pass them.
+//
+//	{
+//	  "Name": "zoned-binpack",
+//	  "Filters": [{"Name": "resources"}, {"Name": "taints"}],
+//	  "Scores": [
+//	    {"Name": "binpack", "Weight": 2},
+//	    {"Name": "topology", "Weight": 1, "Args": {"topologyKey": "zone"}}
+//	  ]
+//	}
+type Profile struct {
+	Name    string
+	Filters []PluginConfig
+	Scores  []PluginConfig
+}
+
+// PluginConfig selects a plugin by name. Weight only applies to score
+// plugins and defaults to 1.
+type PluginConfig struct {
+	Name   string
+	Weight float64
+	Args   map[string]string
+}
+
+var filterPlugins = map[string]func(args map[string]string) (FilterPlugin, error){
+	"stats": func(args map[string]string) (FilterPlugin, error) {
+		maxAge := DefaultMaxStatsAge
+		if v, ok := args["maxAge"]; ok {
+			var err error
+			maxAge, err = time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid maxAge %q: %v", v, err)
+			}
+		}
+		return StatsFilter{MaxAge: maxAge}, nil
+	},
+	"resources": func(map[string]string) (FilterPlugin, error) { return ResourcesFilter{}, nil },
+	"ports":     func(map[string]string) (FilterPlugin, error) { return PortsFilter{}, nil },
+	"labels":    func(map[string]string) (FilterPlugin, error) { return LabelsFilter{}, nil },
+	"taints":    func(map[string]string) (FilterPlugin, error) { return TaintsFilter{}, nil },
+	"topology": func(args map[string]string) (FilterPlugin, error) {
+		maxSkew := 1
+		if v, ok := args["maxSkew"]; ok {
+			var err error
+			maxSkew, err = strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid maxSkew %q: %v", v, err)
+			}
+		}
+		return TopologySpreadFilter{TopologyKey: topologyKey(args), MaxSkew: maxSkew}, nil
+	},
+}
+
+var scorePlugins = map[string]func(args map[string]string) (ScorePlugin, error){
+	"epvm":    func(map[string]string) (ScorePlugin, error) { return EpvmScore{}, nil },
+	"binpack": func(map[string]string) (ScorePlugin, error) { return BinPackScore{}, nil },
+	"spread":  func(map[string]string) (ScorePlugin, error) { return SpreadScore{}, nil },
+	"taints":  func(map[string]string) (ScorePlugin, error) { return TaintsScore{}, nil },
+	"topology": func(args map[string]string) (ScorePlugin, error) {
+		return TopologySpreadScore{TopologyKey: topologyKey(args)}, nil
+	},
+}
+
+func topologyKey(args map[string]string) string {
+	if key, ok := args["topologyKey"]; ok {
+		return key
+	}
+	return "zone"
+}
+
+// LoadProfile reads a scheduler profile from a JSON file and builds its Pipeline
+func LoadProfile(filename string) (*Pipeline, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read scheduler profile %s: %v", filename, err)
+	}
+	var p Profile
+	err = json.Unmarshal(data, &p)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode scheduler profile %s: %v", filename, err)
+	}
+	return p.Build()
+}
+
+// Build creates the Pipeline described by the profile
+func (p Profile) Build() (*Pipeline, error) {
+	pipeline := Pipeline{Name: p.Name}
+	for _, fc := range p.Filters {
+		newPlugin, ok := filterPlugins[fc.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown filter plugin %q", fc.Name)
+		}
+		f, err := newPlugin(fc.Args)
+		if err != nil {
+			return nil, fmt.Errorf("filter plugin %s: %v", fc.Name, err)
+		}
+		pipeline.Filters = append(pipeline.Filters, f)
+	}
+	for _, sc := range p.Scores {
+		newPlugin, ok := scorePlugins[sc.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown score plugin %q", sc.Name)
+		}
+		s, err := newPlugin(sc.Args)
+		if err != nil {
+			return nil, fmt.Errorf("score plugin %s: %v", sc.Name, err)
+		}
+		weight := sc.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		pipeline.Scorers = append(pipeline.Scorers, WeightedScore{Plugin: s, Weight: weight})
+	}
+	return &pipeline, nil
+}
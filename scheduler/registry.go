@@ -0,0 +1,58 @@
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Factory creates a new instance of a scheduler with its default settings
+type Factory func() Scheduler
+
+var registry = map[string]Factory{
+	"roundrobin": func() Scheduler { return &RoundRobin{Name: "roundrobin"} },
+	"epvm":       func() Scheduler { return &Epvm{Name: "epvm"} },
+	"binpack":    func() Scheduler { return newPipeline("binpack", BinPackScore{}) },
+	"spread":     func() Scheduler { return newPipeline("spread", SpreadScore{}) },
+	"topology":   func() Scheduler { return &TopologySpread{Name: "topology", TopologyKey: "zone", MaxSkew: 1} },
+}
+
+// defaultFilters are the filters applied by the built-in pipeline schedulers
+func defaultFilters() []FilterPlugin {
+	return []FilterPlugin{StatsFilter{MaxAge: DefaultMaxStatsAge}, ResourcesFilter{}, PortsFilter{}, LabelsFilter{}, TaintsFilter{}}
+}
+
+// newPipeline returns a Pipeline using the default filters and a single score
+// plugin, along with the penalty for PreferNoSchedule taints
+func newPipeline(name string, score ScorePlugin) *Pipeline {
+	return &Pipeline{
+		Name:    name,
+		Filters: defaultFilters(),
+		Scorers: []WeightedScore{{Plugin: score, Weight: 1}, {Plugin: TaintsScore{}, Weight: 1}},
+	}
+}
+
+// Register makes a scheduler available by name. Registering a name twice
+// replaces the previous factory.
+func Register(name string, f Factory) {
+	registry[name] = f
+}
+
+// New returns the scheduler registered under name
+func New(name string) (Scheduler, error) {
+	f, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown scheduler %q, must be one of: %s", name, strings.Join(Names(), ", "))
+	}
+	return f(), nil
+}
+
+// Names returns the sorted names of all registered schedulers
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
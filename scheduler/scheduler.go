@@ -13,6 +13,10 @@ const (
 	// LIEB square ice constant
 	// https://en.wikipedia.org/wiki/Lieb%27s_square_ice_constant
 	LIEB = 1.53960071783900203869
+
+	// DefaultMaxStatsAge is how old a node's stats may be before schedulers
+	// stop placing tasks on it
+	DefaultMaxStatsAge = 60 * time.Second
 )
 
 // Scheduler determines a set of candidate workers on which task it could run,
@@ -30,22 +34,33 @@ type RoundRobin struct {
 }
 
 type Epvm struct {
-	Name string
+	Name        string
+	MaxStatsAge time.Duration // nodes with older stats are not scheduled; DefaultMaxStatsAge if zero
+}
+
+func (e *Epvm) maxStatsAge() time.Duration {
+	if e.MaxStatsAge == 0 {
+		return DefaultMaxStatsAge
+	}
+	return e.MaxStatsAge
+}
+
+func (r *RoundRobin) FilterPlugins() []FilterPlugin {
+	return []FilterPlugin{StatsFilter{MaxAge: DefaultMaxStatsAge}, ResourcesFilter{}, TaintsFilter{}}
 }
 
 func (r *RoundRobin) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
-	return nodes
+	return filterNodes(t, nodes, r.FilterPlugins()...)
 }
 
 func (r *RoundRobin) Score(t task.Task, nodes []*node.Node) map[string]float64 {
 	nodeScores := make(map[string]float64)
-	var newWorker int
 	if r.LastWorker+1 < len(nodes) {
 		r.LastWorker++
 	} else {
-		newWorker = 0
 		r.LastWorker = 0
 	}
+	newWorker := r.LastWorker
 
 	for idx, node := range nodes {
 		if idx == newWorker {
@@ -53,6 +68,7 @@ func (r *RoundRobin) Score(t task.Task, nodes []*node.Node) map[string]float64 {
 		} else {
 			nodeScores[node.Name] = 1.0
 		}
+		nodeScores[node.Name] += preferNoSchedulePenalty(t, node)
 	}
 	return nodeScores
 }
@@ -79,13 +95,11 @@ func (r *RoundRobin) Pick(scores map[string]float64, candidates []*node.Node) *n
 // in this implementation, the only resources considered for calculating a task's marginal cost are
 // memory and cpu.
 func (e *Epvm) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
-	var candidates []*node.Node
-	for node := range nodes {
-		if checkDisk(t, nodes[node].Disk-nodes[node].DiskAllocated) {
-			candidates = append(candidates, nodes[node])
-		}
-	}
-	return candidates
+	return filterNodes(t, nodes, e.FilterPlugins()...)
+}
+
+func (e *Epvm) FilterPlugins() []FilterPlugin {
+	return []FilterPlugin{StatsFilter{MaxAge: e.maxStatsAge()}, ResourcesFilter{}, TaintsFilter{}}
 }
 
 func checkDisk(t task.Task, diskAvailable int64) bool {
@@ -93,16 +107,26 @@ func checkDisk(t task.Task, diskAvailable int64) bool {
 }
 
 func (e *Epvm) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	nodeScores := e.cost(t, nodes)
+	for _, n := range nodes {
+		if _, ok := nodeScores[n.Name]; ok {
+			nodeScores[n.Name] += preferNoSchedulePenalty(t, n)
+		}
+	}
+	return nodeScores
+}
+
+// cost returns the marginal cost of placing t on each node with fresh stats
+func (e *Epvm) cost(t task.Task, nodes []*node.Node) map[string]float64 {
 	nodeScores := make(map[string]float64)
 	maxJobs := 4.0
 
 	for _, node := range nodes {
-		cpuUsage, err := calculateCpuUsage(node)
-		if err != nil {
-			log.Printf("error calculating CPU usage for node %s, skipping: %v\n", node.Name, err)
+		if !node.StatsFresh(e.maxStatsAge()) {
+			log.Printf("stats for node %s are stale (last updated %v), skipping\n", node.Name, node.StatsUpdated)
 			continue
 		}
-		cpuLoad := calculateLoad(*cpuUsage, math.Pow(2, 0.8))
+		cpuLoad := calculateLoad(node.CpuUsage, math.Pow(2, 0.8))
 		memoryAllocated := float64(node.Stats.MemUsedKb()) + float64(node.MemoryAllocated)
 		memoryPercentAllocated := memoryAllocated / float64(node.Memory)
 
@@ -115,46 +139,6 @@ func (e *Epvm) Score(t task.Task, nodes []*node.Node) map[string]float64 {
 	return nodeScores
 }
 
-// calculateCpuUsage helper function calculates the CPU usage as a float64
-func calculateCpuUsage(node *node.Node) (*float64, error) {
-	stat1, err := node.GetStats()
-	if err != nil {
-		return nil, err
-	}
-	time.Sleep(3 * time.Second)
-	stat2, err := node.GetStats()
-	if err != nil {
-		return nil, err
-	}
-
-	var stat1Idle, stat2Idle float64
-	var stat1NonIdle, stat2NonIdle float64
-
-	for _, stat := range stat1.CpuStats {
-		stat1Idle += stat.Idle + stat.Iowait
-		stat1NonIdle += stat.User + stat.Nice + stat.System + stat.Iowait + stat.Irq + stat.Softirq + stat.Steal
-	}
-
-	for _, stat := range stat2.CpuStats {
-		stat2Idle += stat.Idle + stat.Iowait
-		stat2NonIdle += stat.User + stat.Nice + stat.System + stat.Iowait + stat.Irq + stat.Softirq + stat.Steal
-	}
-
-	stat1Total := stat1Idle + stat1NonIdle
-	stat2Total := stat2Idle + stat2NonIdle
-
-	total := stat2Total - stat1Total
-	idle := stat2Idle - stat1Idle
-
-	var cpuPercentage float64
-	if total == 0 && idle == 0 {
-		cpuPercentage = 0.00
-	} else {
-		cpuPercentage = (float64(total) - float64(idle)/float64(total))
-	}
-	return &cpuPercentage, nil
-}
-
 func calculateLoad(usage float64, capacity float64) float64 {
 	return usage / capacity
 }
@@ -0,0 +1,25 @@
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/wtran29/go-orchestrator/node"
+	"github.com/wtran29/go-orchestrator/task"
+)
+
+func TestRoundRobinTakesNodesInTurn(t *testing.T) {
+	nodes := []*node.Node{
+		node.NewNode("n1", "http://n1", "worker"),
+		node.NewNode("n2", "http://n2", "worker"),
+		node.NewNode("n3", "http://n3", "worker"),
+	}
+	r := &RoundRobin{Name: "roundrobin"}
+	want := []string{"n2", "n3", "n1", "n2", "n3", "n1"}
+	for i, name := range want {
+		tk := task.Task{Name: "web"}
+		picked := r.Pick(r.Score(tk, nodes), nodes)
+		if picked == nil || picked.Name != name {
+			t.Fatalf("placement %d went to %v, want %s", i, picked, name)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package scheduler
+
+import (
+	"github.com/wtran29/go-orchestrator/node"
+	"github.com/wtran29/go-orchestrator/task"
+)
+
+// EpvmScore scores nodes by the E-PVM marginal cost of placing the task on them
+type EpvmScore struct{}
+
+func (EpvmScore) Name() string { return "epvm" }
+
+func (EpvmScore) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	return (&Epvm{}).cost(t, nodes)
+}
+
+// BinPackScore favors the node left with the least free memory and disk once
+// the task is placed, keeping large blocks of capacity free on other nodes and
+// minimizing fragmentation across the cluster
+type BinPackScore struct{}
+
+func (BinPackScore) Name() string { return "binpack" }
+
+func (BinPackScore) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	nodeScores := make(map[string]float64)
+	for _, n := range nodes {
+		memLeft := fractionFree(n.Memory, n.MemoryAllocated+t.Memory)
+		diskLeft := fractionFree(n.Disk, n.DiskAllocated+t.Disk)
+		nodeScores[n.Name] = (memLeft + diskLeft) / 2
+	}
+	return nodeScores
+}
+
+// SpreadScore favors the node with the most free memory and disk, spreading
+// load evenly across the cluster
+type SpreadScore struct{}
+
+func (SpreadScore) Name() string { return "spread" }
+
+func (SpreadScore) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	nodeScores := make(map[string]float64)
+	for _, n := range nodes {
+		memUsed := 1 - fractionFree(n.Memory, n.MemoryAllocated+t.Memory)
+		diskUsed := 1 - fractionFree(n.Disk, n.DiskAllocated+t.Disk)
+		nodeScores[n.Name] = (memUsed + diskUsed) / 2
+	}
+	return nodeScores
+}
+
+// TaintsScore penalizes nodes for every PreferNoSchedule taint the task does
+// not tolerate, so they are only chosen when no untainted node is available
+type TaintsScore struct{}
+
+func (TaintsScore) Name() string { return "taints" }
+
+func (TaintsScore) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	nodeScores := make(map[string]float64)
+	for _, n := range nodes {
+		nodeScores[n.Name] = preferNoSchedulePenalty(t, n)
+	}
+	return nodeScores
+}
+
+// preferNoSchedulePenalty returns the number of PreferNoSchedule taints on n
+// that t does not tolerate. It is added to the scores of the built-in
+// schedulers, outweighing any other preference between nodes.
+func preferNoSchedulePenalty(t task.Task, n *node.Node) float64 {
+	var penalty float64
+	for _, taint := range n.AllTaints() {
+		if taint.Effect == task.PreferNoSchedule && !t.ToleratesTaint(taint) {
+			penalty++
+		}
+	}
+	return penalty
+}
+
+// fractionFree returns the fraction of capacity that remains once allocated is
+// taken out of it. A node that has not reported its capacity yet is treated as empty.
+func fractionFree(capacity int64, allocated int64) float64 {
+	if capacity <= 0 {
+		return 1.0
+	}
+	return float64(capacity-allocated) / float64(capacity)
+}
@@ -0,0 +1,119 @@
+package scheduler
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/wtran29/go-orchestrator/node"
+	"github.com/wtran29/go-orchestrator/task"
+)
+
+// TopologySpread places tasks of the same job evenly across the topology
+// domains (e.g. zones) defined by the node label TopologyKey. A node is only
+// a candidate if placing the task there keeps the difference between the
+// most and least loaded domains within MaxSkew.
+type TopologySpread struct {
+	Name        string
+	TopologyKey string
+	MaxSkew     int
+}
+
+func (ts *TopologySpread) FilterPlugins() []FilterPlugin {
+	return []FilterPlugin{
+		TopologySpreadFilter{TopologyKey: ts.TopologyKey, MaxSkew: ts.MaxSkew},
+		StatsFilter{MaxAge: DefaultMaxStatsAge},
+		ResourcesFilter{},
+		TaintsFilter{},
+	}
+}
+
+func (ts *TopologySpread) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+	// skew is measured against every node, not only those with capacity left
+	candidates := filterNodes(t, nodes, TopologySpreadFilter{TopologyKey: ts.TopologyKey, MaxSkew: ts.MaxSkew})
+	if candidates == nil {
+		log.Printf("[scheduler] placing task %s of job %s would violate max skew %d for topology key %q (counts: %v)\n",
+			t.ID, t.JobName(), ts.MaxSkew, ts.TopologyKey, domainCounts(ts.TopologyKey, t.JobName(), nodes))
+		return nil
+	}
+	return filterNodes(t, candidates, StatsFilter{MaxAge: DefaultMaxStatsAge}, ResourcesFilter{}, TaintsFilter{})
+}
+
+func (ts *TopologySpread) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	nodeScores := TopologySpreadScore{TopologyKey: ts.TopologyKey}.Score(t, nodes)
+	for _, n := range nodes {
+		nodeScores[n.Name] += preferNoSchedulePenalty(t, n)
+	}
+	return nodeScores
+}
+
+func (ts *TopologySpread) Pick(scores map[string]float64, candidates []*node.Node) *node.Node {
+	return pickLowest(scores, candidates)
+}
+
+// TopologySpreadFilter rejects nodes where placing the task would make its
+// job's task count differ between topology domains by more than MaxSkew
+type TopologySpreadFilter struct {
+	TopologyKey string
+	MaxSkew     int
+}
+
+func (TopologySpreadFilter) Name() string { return "topology" }
+
+func (f TopologySpreadFilter) Filter(t task.Task, n *node.Node, nodes []*node.Node) error {
+	domain, ok := n.Labels[f.TopologyKey]
+	if !ok {
+		return fmt.Errorf("node has no topology label %q", f.TopologyKey)
+	}
+	counts := domainCounts(f.TopologyKey, t.JobName(), nodes)
+	if skew := counts[domain] + 1 - minCount(counts); skew > f.MaxSkew {
+		return fmt.Errorf("placing task in %s=%s would make skew %d, max is %d", f.TopologyKey, domain, skew, f.MaxSkew)
+	}
+	return nil
+}
+
+// TopologySpreadScore prefers the least loaded domain and, within a domain,
+// the node running the fewest tasks of the job
+type TopologySpreadScore struct {
+	TopologyKey string
+}
+
+func (TopologySpreadScore) Name() string { return "topology" }
+
+func (s TopologySpreadScore) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	job := t.JobName()
+	counts := domainCounts(s.TopologyKey, job, nodes)
+	nodeScores := make(map[string]float64)
+	for _, n := range nodes {
+		domain := n.Labels[s.TopologyKey]
+		// the node's share of its domain's tasks is always below 1, so it only
+		// breaks ties between nodes in the same domain
+		nodeScores[n.Name] = float64(counts[domain]) + float64(n.JobCounts[job])/float64(counts[domain]+1)
+	}
+	return nodeScores
+}
+
+// domainCounts returns the number of tasks of job running in each topology
+// domain. Domains without any tasks of the job are included with a count of 0.
+func domainCounts(topologyKey string, job string, nodes []*node.Node) map[string]int {
+	counts := make(map[string]int)
+	for _, n := range nodes {
+		domain, ok := n.Labels[topologyKey]
+		if !ok {
+			continue
+		}
+		counts[domain] += n.JobCounts[job]
+	}
+	return counts
+}
+
+func minCount(counts map[string]int) int {
+	first := true
+	var min int
+	for _, c := range counts {
+		if first || c < min {
+			min = c
+			first = false
+		}
+	}
+	return min
+}
@@ -0,0 +1,219 @@
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shirou/gopsutil/mem"
+	"github.com/wtran29/go-orchestrator/node"
+	"github.com/wtran29/go-orchestrator/scheduler"
+	"github.com/wtran29/go-orchestrator/task"
+)
+
+// Arrival is a task submitted to the simulated cluster. Arrival and Duration
+// are in seconds on the simulation's virtual clock.
+type Arrival struct {
+	Arrival  float64
+	Duration float64
+	Task     task.Task
+}
+
+// Report summarizes how a scheduler performed over a trace
+type Report struct {
+	Scheduler         string
+	Tasks             int
+	Placed            int
+	Unplaced          int     // tasks still pending when the trace ended
+	PlacementFailures int     // scheduling attempts that found no node
+	Makespan          float64 // seconds until the last task finished
+	CpuUtilization    float64 // time-weighted fraction of cluster cpu allocated
+	MemUtilization    float64 // time-weighted fraction of cluster memory allocated
+	Fragmentation     float64 // time-weighted fraction of free memory not on the node with the most free memory
+	MeanQueueDelay    float64 // seconds between arrival and placement, for placed tasks
+	MaxQueueDelay     float64
+}
+
+// Simulator runs a scheduler against a synthetic cluster on a virtual clock
+type Simulator struct {
+	Scheduler scheduler.Scheduler
+	Nodes     []*node.Node
+	Trace     []Arrival
+}
+
+// running is a task placed on a node, along with when it will finish
+type running struct {
+	task   task.Task
+	node   *node.Node
+	finish float64
+}
+
+// pending is a task waiting to be placed
+type pending struct {
+	task     task.Task
+	arrival  float64
+	duration float64
+}
+
+// LoadCluster reads a JSON list of node definitions
+func LoadCluster(filename string) ([]*node.Node, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read cluster %s: %v", filename, err)
+	}
+	var nodes []*node.Node
+	err = json.Unmarshal(data, &nodes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode cluster %s: %v", filename, err)
+	}
+	return nodes, nil
+}
+
+// LoadTrace reads a JSON list of task arrivals
+func LoadTrace(filename string) ([]Arrival, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read trace %s: %v", filename, err)
+	}
+	var trace []Arrival
+	err = json.Unmarshal(data, &trace)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode trace %s: %v", filename, err)
+	}
+	return trace, nil
+}
+
+// Run replays the trace and reports the outcome. Pending tasks are retried in
+// arrival order whenever a task arrives or finishes, as the manager retries
+// tasks it could not place.
+func (s *Simulator) Run(name string) Report {
+	trace := make([]Arrival, len(s.Trace))
+	copy(trace, s.Trace)
+	sort.SliceStable(trace, func(i, j int) bool { return trace[i].Arrival < trace[j].Arrival })
+
+	report := Report{Scheduler: name, Tasks: len(trace)}
+	var queue []pending
+	var active []running
+	var now, totalDelay float64
+	var cpuArea, memArea, fragArea float64
+	next := 0
+
+	for next < len(trace) || len(active) > 0 {
+		// advance the clock to the next arrival or completion
+		t := -1.0
+		if next < len(trace) {
+			t = trace[next].Arrival
+		}
+		for _, r := range active {
+			if t < 0 || r.finish < t {
+				t = r.finish
+			}
+		}
+		cpu, memory, frag := s.usage()
+		cpuArea += cpu * (t - now)
+		memArea += memory * (t - now)
+		fragArea += frag * (t - now)
+		now = t
+
+		// complete finished tasks before placing new ones
+		var stillActive []running
+		for _, r := range active {
+			if r.finish <= now {
+				r.node.Release(r.task)
+				report.Makespan = now
+				continue
+			}
+			stillActive = append(stillActive, r)
+		}
+		active = stillActive
+
+		for next < len(trace) && trace[next].Arrival <= now {
+			a := trace[next]
+			if a.Task.ID == uuid.Nil {
+				a.Task.ID = uuid.New()
+			}
+			queue = append(queue, pending{task: a.Task, arrival: a.Arrival, duration: a.Duration})
+			next++
+		}
+
+		var stillPending []pending
+		for _, p := range queue {
+			n := s.place(p.task)
+			if n == nil {
+				report.PlacementFailures++
+				stillPending = append(stillPending, p)
+				continue
+			}
+			n.Allocate(p.task)
+			delay := now - p.arrival
+			totalDelay += delay
+			report.MaxQueueDelay = max(report.MaxQueueDelay, delay)
+			report.Placed++
+			active = append(active, running{task: p.task, node: n, finish: now + p.duration})
+		}
+		queue = stillPending
+	}
+
+	report.Unplaced = len(queue)
+	if report.Placed > 0 {
+		report.MeanQueueDelay = totalDelay / float64(report.Placed)
+	}
+	if now > 0 {
+		report.CpuUtilization = cpuArea / now
+		report.MemUtilization = memArea / now
+		report.Fragmentation = fragArea / now
+	}
+	return report
+}
+
+// place asks the scheduler for a node to run t, as the manager's SelectWorker does
+func (s *Simulator) place(t task.Task) *node.Node {
+	s.refreshStats()
+	candidates := s.Scheduler.SelectCandidateNodes(t, s.Nodes)
+	if candidates == nil {
+		return nil
+	}
+	scores := s.Scheduler.Score(t, candidates)
+	return s.Scheduler.Pick(scores, candidates)
+}
+
+// refreshStats fills in the stats the schedulers read from a live worker,
+// derived from what has been allocated on each simulated node
+func (s *Simulator) refreshStats() {
+	for _, n := range s.Nodes {
+		n.StatsUpdated = time.Now()
+		n.Stats.MemStats = &mem.VirtualMemoryStat{Total: uint64(n.Memory)}
+		if n.Cores > 0 {
+			n.CpuUsage = n.CpuAllocated / float64(n.Cores)
+		}
+	}
+}
+
+// usage returns the fraction of cluster cpu and memory allocated, and how
+// fragmented the free memory is
+func (s *Simulator) usage() (cpu float64, memory float64, frag float64) {
+	var cores, cpuAllocated float64
+	var memTotal, memAllocated, memFree, largestFree int64
+	for _, n := range s.Nodes {
+		cores += float64(n.Cores)
+		cpuAllocated += n.CpuAllocated
+		memTotal += n.Memory
+		memAllocated += n.MemoryAllocated
+		free := n.Memory - n.MemoryAllocated
+		memFree += free
+		largestFree = max(largestFree, free)
+	}
+	if cores > 0 {
+		cpu = cpuAllocated / cores
+	}
+	if memTotal > 0 {
+		memory = float64(memAllocated) / float64(memTotal)
+	}
+	if memFree > 0 {
+		frag = 1 - float64(largestFree)/float64(memFree)
+	}
+	return cpu, memory, frag
+}
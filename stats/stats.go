@@ -5,6 +5,7 @@ import (
 	"github.com/shirou/gopsutil/disk"
 	"github.com/shirou/gopsutil/load"
 	"github.com/shirou/gopsutil/mem"
+	"github.com/wtran29/go-orchestrator/task"
 )
 
 type Stats struct {
@@ -13,6 +14,8 @@ type Stats struct {
 	CpuStats  []cpu.TimesStat
 	LoadStats *load.AvgStat
 	TaskCount int
+	Labels    map[string]string
+	Taints    []task.Taint
 }
 
 // type LoadAvg struct {
@@ -49,6 +52,27 @@ func (s *Stats) DiskUsed() uint64 {
 	return s.DiskStats.Used
 }
 
+// CpuUsageSince returns the fraction of cpu time spent busy, across all cpus,
+// between an earlier sample prev and s
+func (s *Stats) CpuUsageSince(prev *Stats) float64 {
+	var prevIdle, prevTotal, idle, total float64
+	for _, stat := range prev.CpuStats {
+		prevIdle += stat.Idle + stat.Iowait
+		prevTotal += stat.Total()
+	}
+	for _, stat := range s.CpuStats {
+		idle += stat.Idle + stat.Iowait
+		total += stat.Total()
+	}
+
+	totalDelta := total - prevTotal
+	idleDelta := idle - prevIdle
+	if totalDelta <= 0 {
+		return 0.00
+	}
+	return (totalDelta - idleDelta) / totalDelta
+}
+
 type CPUStat struct {
 	Id        string  `json:"id"`
 	User      float64 `json:"user"`
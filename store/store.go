@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync"
 
 	"github.com/boltdb/bolt"
 	"github.com/wtran29/go-orchestrator/task"
@@ -17,8 +18,12 @@ type Store interface {
 	Count() (int, error)
 }
 
-// InMemoryTaskStore provides a wrapper around builtin map type of storing tasks
+// InMemoryTaskStore provides a wrapper around builtin map type of storing tasks.
+// It is safe for concurrent use, but Get and List return the stored tasks
+// themselves, so callers sharing them across goroutines replace a task with
+// Put rather than change it in place.
 type InMemoryTaskStore struct {
+	mu sync.RWMutex // guards Db
 	Db map[string]*task.Task
 }
 
@@ -33,11 +38,15 @@ func (i *InMemoryTaskStore) Put(key string, value interface{}) error {
 	if !ok {
 		return fmt.Errorf("value %v is not a task.Task type", value)
 	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
 	i.Db[key] = t
 	return nil
 }
 
 func (i *InMemoryTaskStore) Get(key string) (interface{}, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 	t, ok := i.Db[key]
 	if !ok {
 		return nil, fmt.Errorf("task with key %s does not exist", key)
@@ -46,6 +55,8 @@ func (i *InMemoryTaskStore) Get(key string) (interface{}, error) {
 }
 
 func (i *InMemoryTaskStore) List() (interface{}, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 	var tasks []*task.Task
 	for _, t := range i.Db {
 		tasks = append(tasks, t)
@@ -54,10 +65,14 @@ func (i *InMemoryTaskStore) List() (interface{}, error) {
 }
 
 func (i *InMemoryTaskStore) Count() (int, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 	return len(i.Db), nil
 }
 
+// InMemoryTaskEventStore is safe for concurrent use, like InMemoryTaskStore
 type InMemoryTaskEventStore struct {
+	mu sync.RWMutex // guards Db
 	Db map[string]*task.TaskEvent
 }
 
@@ -72,11 +87,15 @@ func (i *InMemoryTaskEventStore) Put(key string, value interface{}) error {
 	if !ok {
 		return fmt.Errorf("value %v is not a task.Task type", value)
 	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
 	i.Db[key] = te
 	return nil
 }
 
 func (i *InMemoryTaskEventStore) Get(key string) (interface{}, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 	te, ok := i.Db[key]
 	if !ok {
 		return nil, fmt.Errorf("task with key %s does not exist", key)
@@ -85,6 +104,8 @@ func (i *InMemoryTaskEventStore) Get(key string) (interface{}, error) {
 }
 
 func (i *InMemoryTaskEventStore) List() (interface{}, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 	var events []*task.TaskEvent
 	for _, te := range i.Db {
 		events = append(events, te)
@@ -93,6 +114,8 @@ func (i *InMemoryTaskEventStore) List() (interface{}, error) {
 }
 
 func (i *InMemoryTaskEventStore) Count() (int, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 	return len(i.Db), nil
 }
 
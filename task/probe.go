@@ -0,0 +1,168 @@
+package task
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	// maxProbeResults is how many of the most recent probe results are kept on a task
+	maxProbeResults = 5
+
+	defaultProbePeriod  = 60 * time.Second
+	defaultProbeTimeout = 5 * time.Second
+)
+
+// Probe describes how to check on a task. Exactly one of HTTP, TCP or Exec
+// should be set.
+type Probe struct {
+	HTTP                *HTTPProbe
+	TCP                 *TCPProbe
+	Exec                *ExecProbe
+	InitialDelaySeconds int // wait after the task starts before the first probe
+	PeriodSeconds       int // between probes, 60 if zero
+	TimeoutSeconds      int // for a single probe, 5 if zero
+	FailureThreshold    int // consecutive failures before the probe fails, 1 if zero
+	SuccessThreshold    int // consecutive successes before a failed probe passes again, 1 if zero
+}
+
+// HTTPProbe passes if a GET request to Path on the task's host port returns
+// the expected status and headers
+type HTTPProbe struct {
+	Path            string
+	Port            string            // container port, e.g. "7777/tcp"; the first exposed port if empty
+	Headers         map[string]string // sent with the request
+	ExpectedStatus  int               // 200 if zero
+	ExpectedHeaders map[string]string // response headers that must be present with these values
+}
+
+// TCPProbe passes if a TCP connection can be opened to the task's host port
+type TCPProbe struct {
+	Port string // container port, e.g. "5432/tcp"; the first exposed port if empty
+}
+
+// ExecProbe passes if Command exits with status 0 when run inside the task's container
+type ExecProbe struct {
+	Command []string
+}
+
+// UnmarshalJSON accepts the legacy form of a health check, a path to GET on
+// the task's first host port, in addition to a Probe object
+func (p *Probe) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*p = Probe{HTTP: &HTTPProbe{Path: path}}
+		return nil
+	}
+	type probe Probe
+	return json.Unmarshal(data, (*probe)(p))
+}
+
+func (p *Probe) InitialDelay() time.Duration {
+	return time.Duration(p.InitialDelaySeconds) * time.Second
+}
+
+func (p *Probe) Period() time.Duration {
+	if p.PeriodSeconds <= 0 {
+		return defaultProbePeriod
+	}
+	return time.Duration(p.PeriodSeconds) * time.Second
+}
+
+func (p *Probe) Timeout() time.Duration {
+	if p.TimeoutSeconds <= 0 {
+		return defaultProbeTimeout
+	}
+	return time.Duration(p.TimeoutSeconds) * time.Second
+}
+
+func (p *Probe) Failures() int {
+	if p.FailureThreshold <= 0 {
+		return 1
+	}
+	return p.FailureThreshold
+}
+
+func (p *Probe) Successes() int {
+	if p.SuccessThreshold <= 0 {
+		return 1
+	}
+	return p.SuccessThreshold
+}
+
+// ProbeResult is the outcome of a single probe
+type ProbeResult struct {
+	Time    time.Time
+	Success bool
+	Message string
+}
+
+// ProbeStatus is the recent history of a task's probe
+type ProbeStatus struct {
+	Results              []ProbeResult // most recent last
+	ConsecutiveFailures  int
+	ConsecutiveSuccesses int
+	Failing              bool // set once FailureThreshold is reached, cleared once SuccessThreshold is
+}
+
+// Due reports whether the probe should run again, given when the task started
+func (s *ProbeStatus) Due(p *Probe, started time.Time, now time.Time) bool {
+	if now.Before(started.Add(p.InitialDelay())) {
+		return false
+	}
+	last := s.Last()
+	return last == nil || !now.Before(last.Time.Add(p.Period()))
+}
+
+// Last returns the most recent probe result, or nil if the probe has not run
+func (s *ProbeStatus) Last() *ProbeResult {
+	if len(s.Results) == 0 {
+		return nil
+	}
+	return &s.Results[len(s.Results)-1]
+}
+
+// Record adds a probe result, updating the consecutive counts and whether the
+// probe is failing. It returns true if the result made the probe start failing.
+func (s *ProbeStatus) Record(p *Probe, r ProbeResult) bool {
+	s.Results = append(s.Results, r)
+	if len(s.Results) > maxProbeResults {
+		s.Results = s.Results[len(s.Results)-maxProbeResults:]
+	}
+	if r.Success {
+		s.ConsecutiveSuccesses++
+		s.ConsecutiveFailures = 0
+		if s.Failing && s.ConsecutiveSuccesses >= p.Successes() {
+			s.Failing = false
+		}
+		return false
+	}
+	s.ConsecutiveFailures++
+	s.ConsecutiveSuccesses = 0
+	if !s.Failing && s.ConsecutiveFailures >= p.Failures() {
+		s.Failing = true
+		return true
+	}
+	return false
+}
+
+// RecordReadiness adds a readiness probe result to the task. The task becomes
+// ready after SuccessThreshold consecutive successes and stops being ready
+// after FailureThreshold consecutive failures.
+func (t *Task) RecordReadiness(r ProbeResult) {
+	t.ReadyStatus.Record(t.Readiness, r)
+	switch {
+	case t.ReadyStatus.ConsecutiveSuccesses >= t.Readiness.Successes():
+		t.Ready = true
+	case t.ReadyStatus.ConsecutiveFailures >= t.Readiness.Failures():
+		t.Ready = false
+	}
+}
+
+// ResetProbes clears the results of the task's probes, as when it is
+// restarted, and marks it not ready
+func (t *Task) ResetProbes() {
+	t.HealthStatus = ProbeStatus{}
+	t.ReadyStatus = ProbeStatus{}
+	t.Ready = false
+}
@@ -0,0 +1,65 @@
+package task
+
+import "time"
+
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+
+	defaultRestartAttempts   = 3
+	defaultRestartBackoff    = 10 * time.Second
+	defaultMaxRestartBackoff = 5 * time.Minute
+)
+
+// Restart is the manager's policy for restarting a task once it stops. It is
+// separate from RestartPolicy, which is handed to the docker daemon.
+type Restart struct {
+	Policy            string // never, on-failure or always; on-failure if empty
+	MaxAttempts       int    // restarts before the task is left stopped, 3 if zero, unlimited if negative
+	BackoffSeconds    int    // delay before the first restart, doubled for each restart after it; 10 if zero
+	MaxBackoffSeconds int    // cap on the delay between restarts, 300 if zero
+	RescheduleAfter   int    // consecutive failures on a node before the task is moved to another node, never if zero
+}
+
+// Restarts reports whether the policy restarts a task that stopped in state s
+func (r *Restart) Restarts(s State) bool {
+	switch r.Policy {
+	case RestartNever:
+		return false
+	case RestartAlways:
+		return s == Completed || s == Failed
+	default:
+		return s == Failed
+	}
+}
+
+// AttemptsLeft reports whether a task that has been restarted count times
+// may be restarted again
+func (r *Restart) AttemptsLeft(count int) bool {
+	switch {
+	case r.MaxAttempts < 0:
+		return true
+	case r.MaxAttempts == 0:
+		return count < defaultRestartAttempts
+	default:
+		return count < r.MaxAttempts
+	}
+}
+
+// Backoff returns the delay before restarting a task that has already been
+// restarted count times
+func (r *Restart) Backoff(count int) time.Duration {
+	backoff := defaultRestartBackoff
+	if r.BackoffSeconds > 0 {
+		backoff = time.Duration(r.BackoffSeconds) * time.Second
+	}
+	limit := defaultMaxRestartBackoff
+	if r.MaxBackoffSeconds > 0 {
+		limit = time.Duration(r.MaxBackoffSeconds) * time.Second
+	}
+	for i := 0; i < count && backoff < limit; i++ {
+		backoff *= 2
+	}
+	return min(backoff, limit)
+}
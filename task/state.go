@@ -1,5 +1,11 @@
 package task
 
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
 type State int
 
 const (
@@ -8,18 +14,71 @@ const (
 	Running                // when a worker successfully starts the task (i.e. starts container)
 	Completed              // when it completes its work in a normal way (i.e. does not fail)
 	Failed                 // when a task fails
+	Stopping               // a stop has been requested but the worker has not yet stopped the container
+	Unknown                // the task's worker cannot be reached, so whether it is still running is not known
+	Lost                   // the task's container is gone, either removed outside the worker or with its worker
 )
 
-func (s State) String() []string {
-	return []string{"Pending", "Scheduled", "Running", "Completed", "Failed"}
+var stateNames = []string{"Pending", "Scheduled", "Running", "Completed", "Failed", "Stopping", "Unknown", "Lost"}
+
+func (s State) String() string {
+	if s < 0 || int(s) >= len(stateNames) {
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+	return stateNames[s]
+}
+
+// ParseState returns the state with the given name, ignoring case
+func ParseState(name string) (State, error) {
+	for i, n := range stateNames {
+		if strings.EqualFold(n, name) {
+			return State(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown task state %q", name)
+}
+
+// MarshalJSON encodes the state as its name
+func (s State) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
 }
 
+// UnmarshalJSON accepts the state's name, or the integer it was encoded as
+// before states were encoded by name
+func (s *State) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		var n int
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("task state must be a name or an integer: %s", data)
+		}
+		if n < 0 || n >= len(stateNames) {
+			return fmt.Errorf("unknown task state %d", n)
+		}
+		*s = State(n)
+		return nil
+	}
+	state, err := ParseState(name)
+	if err != nil {
+		return err
+	}
+	*s = state
+	return nil
+}
+
+// stateTransitionMap lists the states a task can move to from each state.
+// A task moves back to Pending when the manager takes it off its worker, e.g.
+// to evict or reschedule it, and places it again; a task stopped before its
+// container started moves straight to Completed.
 var stateTransitionMap = map[State][]State{
-	Pending:   {Scheduled},
-	Scheduled: {Scheduled, Running, Failed},
-	Running:   {Running, Completed, Failed, Scheduled},
-	Completed: {},
-	Failed:    {Scheduled},
+	Pending:   {Pending, Scheduled, Completed, Failed},
+	Scheduled: {Scheduled, Pending, Running, Completed, Failed, Stopping, Unknown, Lost},
+	Running:   {Running, Pending, Completed, Failed, Scheduled, Stopping, Unknown, Lost},
+	Stopping:  {Stopping, Completed, Failed, Unknown, Lost},
+	Unknown:   {Unknown, Pending, Scheduled, Running, Stopping, Completed, Failed, Lost},
+	Completed: {Scheduled, Pending},
+	Failed:    {Scheduled, Pending},
+	Lost:      {Scheduled, Pending},
 }
 
 func Contains(states []State, state State) bool {
@@ -34,3 +93,9 @@ func Contains(states []State, state State) bool {
 func ValidStateTransition(src State, dst State) bool {
 	return Contains(stateTransitionMap[src], dst)
 }
+
+// Terminal reports whether a task in state s is no longer running, so its
+// resources can be released
+func (s State) Terminal() bool {
+	return s == Completed || s == Failed || s == Lost
+}
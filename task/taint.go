@@ -0,0 +1,91 @@
+package task
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// NoSchedule taints keep tasks that do not tolerate them off the node
+	NoSchedule = "NoSchedule"
+	// PreferNoSchedule taints make schedulers avoid the node for tasks that
+	// do not tolerate them, unless no other node is available
+	PreferNoSchedule = "PreferNoSchedule"
+	// NoExecute taints keep tasks that do not tolerate them off the node and
+	// evict those already running there
+	NoExecute = "NoExecute"
+)
+
+// Taint marks a node so that tasks which do not tolerate it are not scheduled there
+type Taint struct {
+	Key    string
+	Value  string
+	Effect string
+}
+
+// String returns the taint in key=value:effect form
+func (t Taint) String() string {
+	return fmt.Sprintf("%s=%s:%s", t.Key, t.Value, t.Effect)
+}
+
+// ParseTaint parses a taint in key=value:effect form
+func ParseTaint(s string) (Taint, error) {
+	kv, effect, ok := strings.Cut(s, ":")
+	if !ok || effect == "" {
+		return Taint{}, fmt.Errorf("taint %q must be in key=value:effect form", s)
+	}
+	key, value, _ := strings.Cut(kv, "=")
+	if key == "" {
+		return Taint{}, fmt.Errorf("taint %q is missing a key", s)
+	}
+	taint := Taint{Key: key, Value: value, Effect: effect}
+	return taint, taint.Validate()
+}
+
+// Validate checks that the taint has a key and a known effect
+func (t Taint) Validate() error {
+	if t.Key == "" {
+		return fmt.Errorf("taint %s is missing a key", t)
+	}
+	switch t.Effect {
+	case NoSchedule, PreferNoSchedule, NoExecute:
+		return nil
+	}
+	return fmt.Errorf("taint %s has unknown effect %q, must be %s, %s or %s", t, t.Effect, NoSchedule, PreferNoSchedule, NoExecute)
+}
+
+// Toleration allows a task to be scheduled onto nodes with a matching taint
+type Toleration struct {
+	Key      string
+	Operator string // "Equal" (default) or "Exists"
+	Value    string
+	Effect   string // empty matches all effects
+}
+
+// Tolerates reports whether the toleration matches the taint
+func (tol Toleration) Tolerates(taint Taint) bool {
+	if tol.Effect != "" && tol.Effect != taint.Effect {
+		return false
+	}
+	if tol.Key == "" {
+		// an empty key with the Exists operator tolerates everything
+		return tol.Operator == "Exists"
+	}
+	if tol.Key != taint.Key {
+		return false
+	}
+	if tol.Operator == "Exists" {
+		return true
+	}
+	return tol.Value == taint.Value
+}
+
+// ToleratesTaint reports whether any of the task's tolerations match the taint
+func (t *Task) ToleratesTaint(taint Taint) bool {
+	for _, tol := range t.Tolerations {
+		if tol.Tolerates(taint) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package task
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
@@ -21,6 +23,8 @@ type Task struct {
 	ID            uuid.UUID
 	ContainerID   string
 	Name          string
+	Job           string // service or job the task belongs to
+	GangSize      int    // if above 1, the job's tasks are only started once this many can all be placed
 	State         State
 	Image         string  // what docker image task should use
 	Cpu           float64 // amount of cpu usage
@@ -28,12 +32,93 @@ type Task struct {
 	Disk          int64   // amount of disk space needed
 	ExposedPorts  nat.PortSet
 	PortBindings  map[string]string
-	RestartPolicy string // ["", "always", "unless-stopped", "on-failure"]
+	RestartPolicy string  // docker restart policy ["", "always", "unless-stopped", "on-failure"]
+	Restart       Restart // manager restart policy
 	StartTime     time.Time
 	FinishTime    time.Time
-	HealthCheck   string
+	HealthCheck   *Probe      // liveness probe, the task is restarted when it fails
+	HealthStatus  ProbeStatus // recent results of the HealthCheck probe
+	Readiness     *Probe      // readiness probe, the task only receives traffic while it passes
+	ReadyStatus   ProbeStatus // recent results of the Readiness probe
+	Ready         bool        // running and, if it has a Readiness probe, passing it
 	RestartCount  int
+	NextRetry     time.Time // when the manager will next restart the task, zero if no restart is planned
+	NodeFailures  int       // consecutive failures on the node the task is placed on
+	AvoidNode     string    // node the task was moved off after failing there repeatedly
 	HostPorts     nat.PortMap
+	NodeSelector  map[string]string // labels a node must have to run the task
+	Tolerations   []Toleration      // taints the task can be scheduled onto
+
+	ActiveDeadlineSeconds     int       // the task is stopped once it has run this long, no limit if zero
+	SchedulingDeadlineSeconds int       // the task fails if it cannot be placed within this long, no limit if zero
+	PendingSince              time.Time // when the task was last queued to be scheduled
+
+	Reason        string // why the task moved to its current state, e.g. DeadlineExceeded
+	StatusMessage string // human-readable detail about the current state, e.g. an error
+}
+
+// Reasons a task moved to its current state
+const (
+	ReasonSubmitted                  = "Submitted"
+	ReasonUnschedulable              = "Unschedulable"
+	ReasonScheduled                  = "Scheduled"
+	ReasonStarted                    = "Started"
+	ReasonStartFailed                = "StartFailed"
+	ReasonExited                     = "Exited"
+	ReasonError                      = "Error"
+	ReasonStopRequested              = "StopRequested"
+	ReasonStopped                    = "Stopped"
+	ReasonContainerMissing           = "ContainerMissing"
+	ReasonInspectFailed              = "InspectFailed"
+	ReasonWorkerUnreachable          = "WorkerUnreachable"
+	ReasonWorkerLost                 = "WorkerLost"
+	ReasonMissingFromWorker          = "MissingFromWorker"
+	ReasonHealthCheckFailed          = "HealthCheckFailed"
+	ReasonReady                      = "Ready"
+	ReasonNotReady                   = "NotReady"
+	ReasonBackOff                    = "BackOff"
+	ReasonRestarted                  = "Restarted"
+	ReasonRescheduled                = "Rescheduled"
+	ReasonEvicted                    = "Evicted"
+	ReasonGangRolledBack             = "GangRolledBack"
+	ReasonDeadlineExceeded           = "DeadlineExceeded"
+	ReasonSchedulingDeadlineExceeded = "SchedulingDeadlineExceeded"
+	ReasonAdopted                    = "Adopted"
+	ReasonRejected                   = "Rejected"
+)
+
+// SetState moves the task to state s, recording why it moved there
+func (t *Task) SetState(s State, reason string, message string) {
+	t.State = s
+	t.Reason = reason
+	t.StatusMessage = message
+}
+
+// JobName returns the name of the job the task belongs to, falling back
+// to the task name when no job was given
+func (t *Task) JobName() string {
+	if t.Job != "" {
+		return t.Job
+	}
+	return t.Name
+}
+
+// ActiveDeadlineExceeded reports whether the task has been running for longer
+// than its ActiveDeadlineSeconds
+func (t *Task) ActiveDeadlineExceeded(now time.Time) bool {
+	if t.ActiveDeadlineSeconds <= 0 || t.StartTime.IsZero() {
+		return false
+	}
+	return now.Sub(t.StartTime) > time.Duration(t.ActiveDeadlineSeconds)*time.Second
+}
+
+// SchedulingDeadlineExceeded reports whether the task has been waiting to be
+// scheduled for longer than its SchedulingDeadlineSeconds
+func (t *Task) SchedulingDeadlineExceeded(now time.Time) bool {
+	if t.SchedulingDeadlineSeconds <= 0 || t.PendingSince.IsZero() {
+		return false
+	}
+	return now.Sub(t.PendingSince) > time.Duration(t.SchedulingDeadlineSeconds)*time.Second
 }
 
 // TaskEvent represents an even that moves a Task from
@@ -43,6 +128,8 @@ type TaskEvent struct {
 	State     State
 	Timestamp time.Time
 	Task      Task
+	Reason    string // set on events the manager records itself, e.g. HealthCheckFailed
+	Message   string
 }
 
 // Config struct to hold Docker container config
@@ -56,12 +143,19 @@ type Config struct {
 	Image        string // Image used to run the container
 	// Memory and Disk serves two purposes:
 	// scheduler use them to find node in cluster
-	Memory        int64    // Memory in MiB
-	Disk          int64    // Disk in GiB
-	Env           []string // allows user to specify env variables passed into the container
-	RestartPolicy string   // tells Docker daemon what to do in event container dies
+	Memory        int64             // Memory in MiB
+	Disk          int64             // Disk in GiB
+	Env           []string          // allows user to specify env variables passed into the container
+	RestartPolicy string            // tells Docker daemon what to do in event container dies
+	Labels        map[string]string // set on the container, e.g. to find the task it was created for
 }
 
+// Labels set on every container created for a task
+const (
+	LabelTaskID = "archon.task-id" // ID of the task the container runs
+	LabelWorker = "archon.worker"  // name of the worker that created the container
+)
+
 func NewConfig(t *Task) *Config {
 	return &Config{
 		Name:          t.Name,
@@ -70,6 +164,7 @@ func NewConfig(t *Task) *Config {
 		Memory:        t.Memory,
 		Disk:          t.Disk,
 		RestartPolicy: t.RestartPolicy,
+		Labels:        map[string]string{LabelTaskID: t.ID.String()},
 	}
 }
 
@@ -114,6 +209,7 @@ func (d *Docker) Run() DockerResult {
 		Tty:          false,
 		Env:          d.Config.Env,
 		ExposedPorts: d.Config.ExposedPorts,
+		Labels:       d.Config.Labels,
 	}
 	hc := container.HostConfig{
 		RestartPolicy:   rp,
@@ -173,6 +269,56 @@ func (d *Docker) Remove(id string) DockerResult {
 	return DockerResult{Action: "removed", Result: "success", Error: nil}
 }
 
+// Exec runs cmd inside the container and returns its exit code and combined output
+func (d *Docker) Exec(ctx context.Context, containerID string, cmd []string) (int, string, error) {
+	exec, err := d.Client.ContainerExecCreate(ctx, containerID, types.ExecConfig{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return -1, "", fmt.Errorf("error creating exec in container %s: %v", containerID, err)
+	}
+	resp, err := d.Client.ContainerExecAttach(ctx, exec.ID, types.ExecStartCheck{})
+	if err != nil {
+		return -1, "", fmt.Errorf("error attaching to exec in container %s: %v", containerID, err)
+	}
+	defer resp.Close()
+
+	var out bytes.Buffer
+	_, err = stdcopy.StdCopy(&out, &out, resp.Reader)
+	if err != nil {
+		return -1, out.String(), fmt.Errorf("error reading exec output from container %s: %v", containerID, err)
+	}
+	inspect, err := d.Client.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return -1, out.String(), fmt.Errorf("error inspecting exec in container %s: %v", containerID, err)
+	}
+	return inspect.ExitCode, out.String(), nil
+}
+
+// List returns the containers, running or not, created by the named worker
+func (d *Docker) List(worker string) ([]types.Container, error) {
+	ctx := context.Background()
+	return d.Client.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", fmt.Sprintf("%s=%s", LabelWorker, worker))),
+	})
+}
+
+// Wait blocks until the container stops running, e.g. because its process
+// exited, and returns an error if the container cannot be waited on
+func (d *Docker) Wait(containerID string) error {
+	ctx := context.Background()
+	statusCh, errCh := d.Client.ContainerWait(ctx, containerID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		return err
+	case <-statusCh:
+		return nil
+	}
+}
+
 type DockerInspectResponse struct {
 	Error     error
 	Container *types.ContainerJSON
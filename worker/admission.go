@@ -0,0 +1,78 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/wtran29/go-orchestrator/task"
+)
+
+// ErrOverCapacity is returned when the worker has no room left for a task
+var ErrOverCapacity = errors.New("worker is over capacity")
+
+// CodeOverCapacity is the ErrResponse code of a task rejected for lack of
+// capacity, so the manager can place the task on another node
+const CodeOverCapacity = "OverCapacity"
+
+// RejectedTask is a task a worker that pulls its work turned away for lack of
+// capacity, reported with its next poll
+type RejectedTask struct {
+	TaskID  uuid.UUID
+	Message string
+}
+
+// Admit queues the task to be started if the worker has room for it: fewer
+// than MaxTasks tasks, and enough memory and cpu left once ReservedMemory and
+// ReservedCpu are kept back for the system. Memory and cpu are only checked
+// once the worker has collected its stats.
+func (w *Worker) Admit(t task.Task) error {
+	w.admitMu.Lock()
+	defer w.admitMu.Unlock()
+
+	count := 1
+	memory := t.Memory
+	cpu := t.Cpu
+	add := func(other task.Task) {
+		if other.ID == t.ID || other.State.Terminal() {
+			return
+		}
+		count++
+		memory += other.Memory
+		cpu += other.Cpu
+	}
+	for _, other := range w.GetTasks() {
+		if _, ok := w.admitted[other.ID]; !ok {
+			add(*other)
+		}
+	}
+	for _, other := range w.admitted {
+		add(other)
+	}
+
+	if w.MaxTasks > 0 && count > w.MaxTasks {
+		return fmt.Errorf("%w: task %s would be task %d of at most %d", ErrOverCapacity, t.ID, count, w.MaxTasks)
+	}
+	if s := w.GetStats(); s != nil {
+		memoryFree := int64(s.MemTotalKb()) - w.ReservedMemory
+		if memory > memoryFree {
+			return fmt.Errorf("%w: task %s needs %d of memory, %d left of %d", ErrOverCapacity, t.ID, t.Memory, memoryFree-(memory-t.Memory), memoryFree)
+		}
+		cpuFree := float64(len(s.CpuStats)) - w.ReservedCpu
+		if cpu > cpuFree {
+			return fmt.Errorf("%w: task %s needs %.2f cpu, %.2f left of %.2f", ErrOverCapacity, t.ID, t.Cpu, cpuFree-(cpu-t.Cpu), cpuFree)
+		}
+	}
+
+	// count the task until it is stored, when the executors get to it
+	w.admitted[t.ID] = t
+	w.AddTask(t)
+	return nil
+}
+
+// started stops counting an admitted task separately, now that it is stored
+func (w *Worker) started(id uuid.UUID) {
+	w.admitMu.Lock()
+	defer w.admitMu.Unlock()
+	delete(w.admitted, id)
+}
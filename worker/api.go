@@ -10,6 +10,7 @@ import (
 type ErrResponse struct {
 	HTTPStatusCode int
 	Message        string
+	Code           string `json:",omitempty"` // e.g. CodeOverCapacity
 }
 
 type Api struct {
@@ -26,6 +27,7 @@ func (a *Api) initRouter() {
 		r.Get("/", a.GetTasksHandler)
 		r.Route("/{taskID}", func(r chi.Router) {
 			r.Delete("/", a.StopTaskHandler)
+			r.Post("/probe", a.ProbeTaskHandler)
 		})
 	})
 	a.Router.Route("/stats", func(r chi.Router) {
@@ -28,7 +28,19 @@ func (a *Api) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(e)
 		return
 	}
-	a.Worker.AddTask(te.Task)
+	err = a.Worker.Admit(te.Task)
+	if err != nil {
+		msg := fmt.Sprintf("Error admitting task: %v\n", err)
+		log.Printf(msg)
+		w.WriteHeader(409)
+		e := ErrResponse{
+			HTTPStatusCode: 409,
+			Message:        msg,
+			Code:           CodeOverCapacity,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
 	log.Printf("Added task %v\n", te.Task.ID)
 	w.WriteHeader(201)
 	json.NewEncoder(w).Encode(te.Task)
@@ -47,23 +59,46 @@ func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(400)
 	}
 	tid, _ := uuid.Parse(taskID)
-	taskToStop, err := a.Worker.Db.Get(tid.String())
+	err := a.Worker.RequestStop(tid)
 	if err != nil {
-		log.Printf("No task with ID %v found", tid)
+		log.Println(err)
 		w.WriteHeader(404)
+		return
 	}
-
-	// we need to make a copy so we are not modifying the task in the datastore
-	taskCopy := *taskToStop.(*task.Task)
-	taskCopy.State = task.Completed
-	a.Worker.AddTask(taskCopy)
-
-	log.Printf("Added task %v to stop container %v\n", taskCopy.ID.String(), taskCopy.ContainerID)
 	w.WriteHeader(204)
 }
 
 func (a *Api) GetStatsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
-	json.NewEncoder(w).Encode(a.Worker.Stats)
+	json.NewEncoder(w).Encode(a.Worker.GetStats())
+}
+
+// ProbeTaskHandler runs the exec probe in the request body inside the task's container
+func (a *Api) ProbeTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskID := chi.URLParam(r, "taskID")
+	tid, _ := uuid.Parse(taskID)
+	result, err := a.Worker.Db.Get(tid.String())
+	if err != nil {
+		log.Printf("No task with ID %v found", tid)
+		w.WriteHeader(404)
+		return
+	}
+
+	p := task.Probe{}
+	err = json.NewDecoder(r.Body).Decode(&p)
+	if err != nil {
+		msg := fmt.Sprintf("Error unmarshalling body: %v\n", err)
+		log.Println(msg)
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(a.Worker.ExecProbe(*result.(*task.Task), &p))
 }
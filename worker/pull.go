@@ -0,0 +1,96 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/wtran29/go-orchestrator/stats"
+	"github.com/wtran29/go-orchestrator/task"
+)
+
+// PollRequest is sent by a worker that pulls its work from the manager. It
+// reports what the manager would otherwise ask the worker for.
+type PollRequest struct {
+	Stats    *stats.Stats
+	Tasks    []task.Task
+	Rejected []RejectedTask // tasks handed out by the last poll that the worker had no room for
+}
+
+// PollResponse is the work the manager hands a polling worker: tasks to start,
+// and tasks to stop, sent as events in the Completed state
+type PollResponse struct {
+	Work []task.TaskEvent
+}
+
+// Pull repeatedly polls the manager for work, so the manager never needs to
+// connect to the worker, e.g. when the worker is behind NAT. Each poll is
+// held open by the manager until there is work or it times out.
+func (w *Worker) Pull() {
+	client := &http.Client{Timeout: 30 * time.Second}
+	for {
+		work, err := w.poll(client)
+		if err != nil {
+			log.Printf("[worker] %v\n", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		for _, te := range work {
+			if te.State == task.Completed {
+				err := w.RequestStop(te.Task.ID)
+				if err != nil {
+					log.Printf("[worker] %v\n", err)
+				}
+				continue
+			}
+			err := w.Admit(te.Task)
+			if err != nil {
+				log.Printf("[worker] %v\n", err)
+				w.keepRejected([]RejectedTask{{TaskID: te.Task.ID, Message: err.Error()}})
+				continue
+			}
+			log.Printf("Added task %v\n", te.Task.ID)
+		}
+	}
+}
+
+func (w *Worker) poll(client *http.Client) ([]task.TaskEvent, error) {
+	req := PollRequest{Stats: w.GetStats(), Rejected: w.rejected}
+	w.rejected = nil
+	for _, t := range w.GetTasks() {
+		req.Tasks = append(req.Tasks, *t)
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal poll request: %v", err)
+	}
+	u := fmt.Sprintf("http://%s/workers/%s/poll", w.Manager, url.PathEscape(w.Address))
+	resp, err := client.Post(u, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		w.keepRejected(req.Rejected)
+		return nil, fmt.Errorf("error polling %s for work: %v", w.Manager, err)
+	}
+	defer resp.Body.Close()
+	d := json.NewDecoder(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		w.keepRejected(req.Rejected)
+		e := ErrResponse{}
+		d.Decode(&e)
+		return nil, fmt.Errorf("manager %s rejected poll (%d): %s", w.Manager, resp.StatusCode, e.Message)
+	}
+	var pr PollResponse
+	err = d.Decode(&pr)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding poll response: %v", err)
+	}
+	return pr.Work, nil
+}
+
+// keepRejected holds rejected tasks to be reported with the next poll
+func (w *Worker) keepRejected(rejected []RejectedTask) {
+	w.rejected = append(w.rejected, rejected...)
+}
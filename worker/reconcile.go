@@ -0,0 +1,147 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wtran29/go-orchestrator/task"
+)
+
+// Container is a container labeled as created by the worker for a task the
+// worker does not know, e.g. because it restarted with the memory store
+type Container struct {
+	TaskID      uuid.UUID
+	ContainerID string
+	State       string // as reported by Docker, e.g. running or exited
+}
+
+// Reconcile finds the containers the worker created before it restarted. It
+// adopts those running its own tasks, asks the manager about those running
+// tasks it no longer knows, and removes the rest if cleanup is set.
+func (w *Worker) Reconcile(cleanup bool) error {
+	d := task.NewDocker(&task.Config{})
+	containers, err := d.List(w.Name)
+	if err != nil {
+		return fmt.Errorf("unable to list containers of worker %s: %v", w.Name, err)
+	}
+
+	var unknown []Container
+	var orphans []string
+	for _, c := range containers {
+		id, err := uuid.Parse(c.Labels[task.LabelTaskID])
+		if err != nil {
+			orphans = append(orphans, c.ID)
+			continue
+		}
+		result, err := w.Db.Get(id.String())
+		if err != nil {
+			unknown = append(unknown, Container{TaskID: id, ContainerID: c.ID, State: c.State})
+			continue
+		}
+		t := *result.(*task.Task)
+		if !w.adopt(&t, c.ID) {
+			orphans = append(orphans, c.ID)
+		}
+	}
+
+	if len(unknown) > 0 {
+		claimed, err := w.reportContainers(unknown)
+		if err != nil {
+			// without the manager's answer, keep the containers in case it still wants them
+			log.Printf("[worker] %v\n", err)
+		}
+		tasks := make(map[uuid.UUID]task.Task)
+		for _, t := range claimed {
+			tasks[t.ID] = t
+		}
+		for _, c := range unknown {
+			t, ok := tasks[c.TaskID]
+			switch {
+			case ok:
+				if !w.adopt(&t, c.ContainerID) {
+					orphans = append(orphans, c.ContainerID)
+				}
+			case err == nil:
+				orphans = append(orphans, c.ContainerID)
+			}
+		}
+	}
+
+	for _, id := range orphans {
+		if !cleanup {
+			log.Printf("[worker] container %s does not run any of the worker's tasks, leaving it\n", id)
+			continue
+		}
+		log.Printf("[worker] removing orphaned container %s\n", id)
+		d.Stop(id)
+		d.Remove(id)
+	}
+	return nil
+}
+
+// adopt makes the worker manage the task's existing container again, updating
+// t, a copy of the task, in place. It reports false if the container is not
+// the task's current one.
+func (w *Worker) adopt(t *task.Task, containerID string) bool {
+	if t.State.Terminal() {
+		return false
+	}
+	if t.ContainerID != "" && t.ContainerID != containerID && t.State != task.Scheduled {
+		return false
+	}
+	log.Printf("[worker] adopting container %s of task %s\n", containerID, t.ID)
+	t.ContainerID = containerID
+	if t.State == task.Stopping {
+		// finish the stop the worker was asked for before it restarted
+		w.Db.Put(t.ID.String(), t)
+		stop := *t
+		stop.State = task.Completed
+		w.AddTask(stop)
+		return true
+	}
+	t.SetState(task.Unknown, task.ReasonAdopted, fmt.Sprintf("adopted container %s after the worker restarted", containerID))
+	w.Db.Put(t.ID.String(), t)
+	// find out whether the container is still running
+	w.updateTask(t)
+	if t.State == task.Running && w.Manager != "" {
+		go w.waitTask(task.NewDocker(task.NewConfig(t)), *t)
+	}
+	return true
+}
+
+// reportContainers sends the manager the containers of tasks the worker does
+// not know, and returns the tasks the manager still has assigned to the worker
+func (w *Worker) reportContainers(containers []Container) ([]task.Task, error) {
+	if w.Manager == "" {
+		return nil, fmt.Errorf("no manager to report %d containers of unknown tasks to", len(containers))
+	}
+	data, err := json.Marshal(containers)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal containers: %v", err)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	u := fmt.Sprintf("http://%s/workers/%s/containers", w.Manager, url.PathEscape(w.Address))
+	resp, err := client.Post(u, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return nil, fmt.Errorf("error reporting containers to %s: %v", w.Manager, err)
+	}
+	defer resp.Body.Close()
+	d := json.NewDecoder(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		e := ErrResponse{}
+		d.Decode(&e)
+		return nil, fmt.Errorf("manager %s rejected containers (%d): %s", w.Manager, resp.StatusCode, e.Message)
+	}
+	var tasks []task.Task
+	err = d.Decode(&tasks)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding claimed tasks: %v", err)
+	}
+	return tasks, nil
+}
@@ -0,0 +1,91 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/wtran29/go-orchestrator/task"
+)
+
+// updateQueue is how many task state changes can wait to be pushed to the manager
+const updateQueue = 100
+
+// StatusReport is a task's state as pushed by a worker to the manager
+type StatusReport struct {
+	Worker string // address the manager knows the worker by
+	Task   task.Task
+}
+
+// notify queues a task state change to be pushed to the manager. Changes
+// that do not fit in the queue are dropped; the manager still finds them
+// when it next polls the worker's tasks.
+func (w *Worker) notify(t task.Task) {
+	if w.Manager == "" {
+		return
+	}
+	select {
+	case w.updates <- t:
+	default:
+		log.Printf("[worker] dropping state update for task %s, the manager will poll for it\n", t.ID)
+	}
+}
+
+// PushUpdates sends task state changes to the manager as they happen
+func (w *Worker) PushUpdates() {
+	for t := range w.updates {
+		err := w.pushUpdate(t)
+		if err != nil {
+			log.Printf("[worker] %v\n", err)
+		}
+	}
+}
+
+func (w *Worker) pushUpdate(t task.Task) error {
+	data, err := json.Marshal(StatusReport{Worker: w.Address, Task: t})
+	if err != nil {
+		return fmt.Errorf("unable to marshal status of task %s: %v", t.ID, err)
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	url := fmt.Sprintf("http://%s/tasks/%s/status", w.Manager, t.ID)
+	resp, err := client.Post(url, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return fmt.Errorf("error pushing status of task %s to %s: %v", t.ID, w.Manager, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("manager %s rejected status of task %s: %d", w.Manager, t.ID, resp.StatusCode)
+	}
+	return nil
+}
+
+// waitTask waits for the task's container to stop and then records the
+// task's new state straight away, rather than at the next poll of the
+// worker's tasks
+func (w *Worker) waitTask(d *task.Docker, t task.Task) {
+	err := d.Wait(t.ContainerID)
+	if err != nil {
+		log.Printf("[worker] unable to wait on container %s of task %s: %v\n", t.ContainerID, t.ID, err)
+		return
+	}
+	// queued work on the task records its own state, so leave the task to it
+	done, ok := w.claimTask(t.ID)
+	if !ok {
+		return
+	}
+	defer done()
+	result, err := w.Db.Get(t.ID.String())
+	if err != nil {
+		log.Printf("[worker] %v\n", err)
+		return
+	}
+	stored := *result.(*task.Task)
+	// the task may have been stopped or restarted in the meantime
+	if stored.ContainerID != t.ContainerID || (stored.State != task.Running && stored.State != task.Unknown) {
+		return
+	}
+	w.updateTask(&stored)
+}
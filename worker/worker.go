@@ -1,30 +1,63 @@
 package worker
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
+	"github.com/docker/docker/client"
 	"github.com/golang-collections/collections/queue"
+	"github.com/google/uuid"
 	"github.com/wtran29/go-orchestrator/stats"
 	"github.com/wtran29/go-orchestrator/store"
 	"github.com/wtran29/go-orchestrator/task"
 )
 
+// DefaultExecutors is how many queued tasks a worker starts or stops at once
+// unless configured otherwise
+const DefaultExecutors = 4
+
 // Worker runs and keep tracks of all the tasks
 type Worker struct {
 	Name      string
-	Queue     queue.Queue  // tasks handled in (FIFO)
-	Db        store.Store  // to keep track of tasks
-	Stats     *stats.Stats // keep track of stats
-	TaskCount int          //keep track of number of tasks as worker
+	Queue     queue.Queue       // tasks handled in (FIFO)
+	Db        store.Store       // to keep track of tasks
+	Stats     *stats.Stats      // keep track of stats; read with GetStats
+	TaskCount int               //keep track of number of tasks as worker
+	Labels    map[string]string // topology labels reported to the manager
+	Taints    []task.Taint      // taints reported to the manager
+	Manager   string            // manager that task state changes are pushed to, e.g. localhost:5555; none if empty
+	Address   string            // address the manager knows the worker by, e.g. localhost:5556
+	Executors int               // how many queued tasks are started or stopped at once
+	// capacity, beyond which Admit turns tasks away
+	MaxTasks       int            // most tasks run at once; no limit if 0
+	ReservedMemory int64          // memory kept back for the system, in bytes like task.Task.Memory
+	ReservedCpu    float64        // cpus kept back for the system
+	updates        chan task.Task // task state changes waiting to be pushed to the manager
+
+	statsMu sync.RWMutex // guards Stats and TaskCount
+
+	queueMu   sync.Mutex                  // guards Queue and taskTurns
+	taskTurns map[uuid.UUID]chan struct{} // closed when the latest work on each task is done
+	wake      chan struct{}               // wakes an idle executor when work is queued
+
+	admitMu  sync.Mutex              // guards admitted
+	admitted map[uuid.UUID]task.Task // admitted tasks not yet stored by an executor
+	rejected []RejectedTask          // rejected tasks to report with the next poll; only used by Pull
 }
 
 func New(name string, taskDBtype string) *Worker {
 	w := Worker{
-		Name:  name,
-		Queue: *queue.New(),
+		Name:      name,
+		Queue:     *queue.New(),
+		updates:   make(chan task.Task, updateQueue),
+		Executors: DefaultExecutors,
+		taskTurns: make(map[uuid.UUID]chan struct{}),
+		wake:      make(chan struct{}, 1),
+		admitted:  make(map[uuid.UUID]task.Task),
 	}
 	var s store.Store
 	var err error
@@ -44,95 +77,235 @@ func New(name string, taskDBtype string) *Worker {
 }
 
 // RunTask handles running a task on the machine where worker is running
-func (w *Worker) runTask() task.DockerResult {
-	// pull task out of the queue
-	t := w.Queue.Dequeue()
-	if t == nil {
-		log.Println("[worker] No tasks in the queue")
-		return task.DockerResult{Error: nil}
-	}
-	// convert task from an interface to a task.Task type
-	taskQueued := t.(task.Task)
+func (w *Worker) runTask(taskQueued task.Task) task.DockerResult {
+	defer w.started(taskQueued.ID)
 	fmt.Printf("[worker] Found task in queue: %v\n", taskQueued)
 
+	// check the move from the state the worker last recorded for the task,
+	// if it has seen the task before
+	if result, err := w.Db.Get(taskQueued.ID.String()); err == nil {
+		stored := result.(*task.Task)
+		current := stored.State
+		if current != taskQueued.State && !task.ValidStateTransition(current, taskQueued.State) {
+			err := fmt.Errorf("invalid transition from %v to %v", current, taskQueued.State)
+			return task.DockerResult{Error: err}
+		}
+		if taskQueued.State == task.Completed && taskQueued.ContainerID == "" {
+			// stopped before it was started, so stop the container it was given
+			taskQueued.ContainerID = stored.ContainerID
+		}
+	}
+
 	err := w.Db.Put(taskQueued.ID.String(), &taskQueued)
 	if err != nil {
 		msg := fmt.Errorf("error stroing task %s: %v", taskQueued.ID.String(), err)
 		log.Println(msg)
 		return task.DockerResult{Error: msg}
 	}
-	result, err := w.Db.Get(taskQueued.ID.String())
-	if err != nil {
-		msg := fmt.Errorf("error getting task %s from database: %v", taskQueued.ID.String(), err)
-		log.Println(msg)
-		return task.DockerResult{Error: msg}
-	}
-	// retrieve task from worker's Db
-	taskPersisted := *result.(*task.Task)
-	if taskPersisted.State == task.Completed {
-		return w.StopTask(taskPersisted)
-	}
+
 	var dockerResult task.DockerResult
-	if task.ValidStateTransition(taskPersisted.State, taskQueued.State) {
-		switch taskQueued.State {
-		case task.Scheduled:
-			if taskQueued.ContainerID != "" {
-				dockerResult = w.StopTask(taskQueued)
-				if dockerResult.Error != nil {
-					log.Printf("%v\n", dockerResult.Error)
-				}
+	switch taskQueued.State {
+	case task.Completed:
+		dockerResult = w.StopTask(taskQueued)
+	case task.Scheduled:
+		if taskQueued.ContainerID != "" {
+			// a restart: remove the old container without reporting the
+			// task Completed, as it is about to run again
+			if result := w.removeContainer(taskQueued); result.Error != nil {
+				log.Printf("%v\n", result.Error)
 			}
-			dockerResult = w.StartTask(taskQueued)
-		default:
-			fmt.Printf("This is a mistake. taskPersisted: %v, taskQueued: %v\n", taskPersisted, taskQueued)
-			dockerResult.Error = errors.New("we should not get here")
 		}
-	} else {
-		err := fmt.Errorf("invalid transition from %v to %v", taskPersisted.State, taskQueued.State)
-		dockerResult.Error = err
-		return dockerResult
+		dockerResult = w.StartTask(taskQueued)
+	default:
+		fmt.Printf("This is a mistake. taskQueued: %v\n", taskQueued)
+		dockerResult.Error = errors.New("we should not get here")
 	}
 	return dockerResult
 }
 
+// RunTasks starts and stops the queued tasks with the worker's pool of
+// executors
 func (w *Worker) RunTasks() {
+	n := w.Executors
+	if n < 1 {
+		n = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.execute()
+		}()
+	}
+	wg.Wait()
+}
+
+// execute runs queued tasks one after another, waiting for AddTask to queue
+// more when there are none
+func (w *Worker) execute() {
 	for {
-		if w.Queue.Len() != 0 {
-			result := w.runTask()
-			if result.Error != nil {
-				log.Printf("Error running task: %v\n", result.Error)
-			}
-		} else {
-			log.Printf("No tasks to process currently.\n")
+		t, done, ok := w.nextTask()
+		if !ok {
+			<-w.wake
+			continue
+		}
+		result := w.runTask(t)
+		done()
+		if result.Error != nil {
+			log.Printf("Error running task: %v\n", result.Error)
 		}
-		log.Println("Sleeping for 10 seconds.")
-		time.Sleep(10 * time.Second)
 	}
 }
 
+// nextTask takes the next task off the queue. Work on the same task runs in
+// the order it was queued and never at once, so the task's start and stop
+// cannot race; the returned function must be called once the work is done.
+func (w *Worker) nextTask() (task.Task, func(), bool) {
+	w.queueMu.Lock()
+	if w.Queue.Len() == 0 {
+		w.queueMu.Unlock()
+		return task.Task{}, nil, false
+	}
+	t := w.Queue.Dequeue().(task.Task)
+	if w.Queue.Len() > 0 {
+		// hand the rest of the queue to another executor
+		w.signal()
+	}
+	// take a turn after the work queued before on the same task
+	prev := w.taskTurns[t.ID]
+	turn := make(chan struct{})
+	w.taskTurns[t.ID] = turn
+	w.queueMu.Unlock()
+
+	if prev != nil {
+		<-prev
+	}
+	return t, func() { w.endTurn(t.ID, turn) }, true
+}
+
+// claimTask takes a turn on the task for work from outside the queue, such as
+// recording the state of its container, so it does not race the executors.
+// It reports false if queued work on the task is running or waiting, rather
+// than hold up the caller behind a slow start or stop; the returned function
+// must be called once the work is done.
+func (w *Worker) claimTask(id uuid.UUID) (func(), bool) {
+	w.queueMu.Lock()
+	defer w.queueMu.Unlock()
+	if _, busy := w.taskTurns[id]; busy {
+		return nil, false
+	}
+	turn := make(chan struct{})
+	w.taskTurns[id] = turn
+	return func() { w.endTurn(id, turn) }, true
+}
+
+// endTurn lets the next work on the task go ahead
+func (w *Worker) endTurn(id uuid.UUID, turn chan struct{}) {
+	w.queueMu.Lock()
+	defer w.queueMu.Unlock()
+	if w.taskTurns[id] == turn {
+		delete(w.taskTurns, id)
+	}
+	close(turn)
+}
+
 func (w *Worker) AddTask(t task.Task) {
+	w.queueMu.Lock()
 	w.Queue.Enqueue(t)
+	w.queueMu.Unlock()
+	w.signal()
+}
+
+// signal wakes an idle executor
+func (w *Worker) signal() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// RequestStop marks the task as stopping and queues its container to be
+// stopped. The task is only marked if no other work on it is under way, as
+// that work would record its own state over the mark. A task admitted but not
+// yet started is stopped once it has been.
+func (w *Worker) RequestStop(id uuid.UUID) error {
+	w.admitMu.Lock()
+	admitted, ok := w.admitted[id]
+	w.admitMu.Unlock()
+	if ok {
+		// the task is queued to be started, so stop it once it has been,
+		// along with whatever container it is started in
+		admitted.State = task.Completed
+		admitted.ContainerID = ""
+		w.AddTask(admitted)
+		log.Printf("Added task %v to stop once it has started\n", id)
+		return nil
+	}
+	taskToStop, err := w.Db.Get(id.String())
+	if err != nil {
+		return fmt.Errorf("no task with ID %v found", id)
+	}
+
+	// we need to make a copy so we are not modifying the task in the datastore
+	taskCopy := *taskToStop.(*task.Task)
+	if done, ok := w.claimTask(id); ok {
+		if result, err := w.Db.Get(id.String()); err == nil {
+			taskCopy = *result.(*task.Task)
+		}
+		if task.ValidStateTransition(taskCopy.State, task.Stopping) {
+			stopping := taskCopy
+			stopping.SetState(task.Stopping, task.ReasonStopRequested, "stopping container")
+			w.Db.Put(stopping.ID.String(), &stopping)
+			w.notify(stopping)
+		}
+		done()
+	}
+	taskCopy.State = task.Completed
+	w.AddTask(taskCopy)
+
+	log.Printf("Added task %v to stop container %v\n", taskCopy.ID.String(), taskCopy.ContainerID)
+	return nil
 }
 
 // StartTask starts a task
 func (w *Worker) StartTask(t task.Task) task.DockerResult {
 	config := task.NewConfig(&t)
+	config.Labels[task.LabelWorker] = w.Name
 	d := task.NewDocker(config)
 	result := d.Run()
 	if result.Error != nil {
 		log.Printf("Error running task %v: %v\n", t.ID, result.Error)
-		t.State = task.Failed
+		t.SetState(task.Failed, task.ReasonStartFailed, result.Error.Error())
 		w.Db.Put(t.ID.String(), &t)
+		w.notify(t)
 		return result
 	}
 	t.ContainerID = result.ContainerId
-	t.State = task.Running
+	t.SetState(task.Running, task.ReasonStarted, fmt.Sprintf("started container %s on %s", result.ContainerId, w.Name))
+	t.StartTime = time.Now().UTC()
 	w.Db.Put(t.ID.String(), &t)
+	w.notify(t)
+	if w.Manager != "" {
+		go w.waitTask(d, t)
+	}
 	return result
 }
 
 // StopTask stops a task
 func (w *Worker) StopTask(t task.Task) task.DockerResult {
+	removeResult := w.removeContainer(t)
+	t.FinishTime = time.Now().UTC()
+	t.SetState(task.Completed, task.ReasonStopped, fmt.Sprintf("stopped and removed container %s", t.ContainerID))
+	w.Db.Put(t.ID.String(), &t)
+	w.notify(t)
+	log.Printf("Stopped and removed container %v for task %v\n", t.ContainerID, t.ID)
+	return removeResult
+}
+
+// removeContainer stops and removes the task's container, leaving the
+// task's state alone
+func (w *Worker) removeContainer(t task.Task) task.DockerResult {
 	config := task.NewConfig(&t)
 	d := task.NewDocker(config)
 
@@ -144,10 +317,6 @@ func (w *Worker) StopTask(t task.Task) task.DockerResult {
 	if removeResult.Error != nil {
 		log.Printf("Error removing container: %v\n", removeResult.Error)
 	}
-	t.FinishTime = time.Now().UTC()
-	t.State = task.Completed
-	w.Db.Put(t.ID.String(), &t)
-	log.Printf("Stopped and removed container %v for task %v\n", t.ContainerID, t.ID)
 	return removeResult
 }
 
@@ -162,16 +331,33 @@ func (w *Worker) GetTasks() []*task.Task {
 
 }
 
+// GetStats returns the worker's latest stats, or nil if none have been
+// collected yet. The stats must not be changed.
+func (w *Worker) GetStats() *stats.Stats {
+	w.statsMu.RLock()
+	defer w.statsMu.RUnlock()
+	return w.Stats
+}
+
 // CollectStats periodically collect stats about the worker
 func (w *Worker) CollectStats() {
 	for {
-		log.Println("Collecting stats")
-		w.Stats = stats.GetStats()
-		w.TaskCount = w.Stats.TaskCount
+		w.collectStats()
 		time.Sleep(15 * time.Second)
 	}
 }
 
+func (w *Worker) collectStats() {
+	log.Println("Collecting stats")
+	s := stats.GetStats()
+	s.Labels = w.Labels
+	s.Taints = w.Taints
+	w.statsMu.Lock()
+	w.Stats = s
+	w.TaskCount = s.TaskCount
+	w.statsMu.Unlock()
+}
+
 // InspectTask method calls new Inspect method on the Docker struct
 func (w *Worker) InspectTask(t task.Task) task.DockerInspectResponse {
 	config := task.NewConfig(&t)
@@ -179,6 +365,30 @@ func (w *Worker) InspectTask(t task.Task) task.DockerInspectResponse {
 	return d.Inspect(t.ContainerID)
 }
 
+// ExecProbe runs an exec probe's command inside the task's container
+func (w *Worker) ExecProbe(t task.Task, p *task.Probe) task.ProbeResult {
+	result := task.ProbeResult{Time: time.Now().UTC()}
+	if p.Exec == nil || len(p.Exec.Command) == 0 {
+		result.Message = "probe has no command to run"
+		return result
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.Timeout())
+	defer cancel()
+
+	config := task.NewConfig(&t)
+	d := task.NewDocker(config)
+	exitCode, output, err := d.Exec(ctx, t.ContainerID, p.Exec.Command)
+	switch {
+	case err != nil:
+		result.Message = err.Error()
+	case exitCode != 0:
+		result.Message = fmt.Sprintf("command %v exited with %d: %s", p.Exec.Command, exitCode, output)
+	default:
+		result.Success = true
+	}
+	return result
+}
+
 // UpdateTasks serves as a wrapper to updateTasks method
 func (w *Worker) UpdateTasks() {
 	for {
@@ -197,27 +407,75 @@ func (w *Worker) updateTasks() {
 		return
 	}
 	for _, t := range tasks.([]*task.Task) {
-		if t.State == task.Running {
-			// call InspectTask method to get task state from docker daemon
-			resp := w.InspectTask(*t)
-			if resp.Error != nil {
-				fmt.Printf("ERROR: %v\n", resp.Error)
+		if t.State != task.Running && t.State != task.Unknown {
+			continue
+		}
+		// a task being started or stopped is checked next time
+		done, ok := w.claimTask(t.ID)
+		if !ok {
+			continue
+		}
+		result, err := w.Db.Get(t.ID.String())
+		if err == nil {
+			current := *result.(*task.Task)
+			if current.State == task.Running || current.State == task.Unknown {
+				w.updateTask(&current)
 			}
-			// verify task is in running state
-			if resp.Container == nil {
-				log.Printf("No container for running task %s\n", t.ID)
-				t.State = task.Failed
-				w.Db.Put(t.ID.String(), t)
+		}
+		done()
+	}
+}
+
+// updateTask inspects the task's container and records the task's state,
+// pushing it to the manager if it changed. The caller holds the task's turn
+// and passes a copy of the stored task, which is updated in place.
+func (w *Worker) updateTask(t *task.Task) {
+	prev := t.State
+	// call InspectTask method to get task state from docker daemon
+	resp := w.InspectTask(*t)
+	if resp.Error != nil {
+		fmt.Printf("ERROR: %v\n", resp.Error)
+	}
+	if resp.Container == nil {
+		if client.IsErrNotFound(resp.Error) {
+			// the container was removed outside of the worker
+			log.Printf("No container for running task %s\n", t.ID)
+			t.SetState(task.Lost, task.ReasonContainerMissing, fmt.Sprintf("container %s no longer exists", t.ContainerID))
+		} else {
+			log.Printf("Unable to inspect container for task %s\n", t.ID)
+			t.SetState(task.Unknown, task.ReasonInspectFailed, fmt.Sprint(resp.Error))
+		}
+		w.Db.Put(t.ID.String(), t)
+		if t.State != prev {
+			w.notify(*t)
+		}
+		return
+	}
+	// if the container exited, the task completed if it exited cleanly
+	// and failed otherwise
+	if resp.Container.State.Status == "exited" {
+		log.Printf("Container for task %s in non-running state %s with exit code %d\n", t.ID, resp.Container.State.Status, resp.Container.State.ExitCode)
+		exitCode := resp.Container.State.ExitCode
+		if exitCode == 0 {
+			t.SetState(task.Completed, task.ReasonExited, "container exited with code 0")
+		} else {
+			message := fmt.Sprintf("container exited with code %d", exitCode)
+			if resp.Container.State.Error != "" {
+				message = fmt.Sprintf("%s: %s", message, resp.Container.State.Error)
 			}
-			// if not in running state, or not running at all, set task state to failed
-			if resp.Container.State.Status == "exited" {
-				log.Printf("Container for task %s in non-running state %s\n", t.ID, resp.Container.State.Status)
-				t.State = task.Failed
-				w.Db.Put(t.ID.String(), t)
+			if resp.Container.State.OOMKilled {
+				message = fmt.Sprintf("%s (out of memory)", message)
 			}
-			// task is running, update exposed ports
-			t.HostPorts = resp.Container.NetworkSettings.NetworkSettingsBase.Ports
-			w.Db.Put(t.ID.String(), t)
+			t.SetState(task.Failed, task.ReasonError, message)
 		}
+		t.FinishTime = time.Now().UTC()
+	} else if t.State == task.Unknown {
+		t.SetState(task.Running, task.ReasonStarted, fmt.Sprintf("container %s is %s", t.ContainerID, resp.Container.State.Status))
+	}
+	// update exposed ports
+	t.HostPorts = resp.Container.NetworkSettings.NetworkSettingsBase.Ports
+	w.Db.Put(t.ID.String(), t)
+	if t.State != prev {
+		w.notify(*t)
 	}
 }
@@ -0,0 +1,217 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wtran29/go-orchestrator/task"
+)
+
+func TestMain(m *testing.M) {
+	// the worker logs every step it takes
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// drained reports whether the worker has no queued work and no work under way
+func drained(w *Worker) bool {
+	w.queueMu.Lock()
+	defer w.queueMu.Unlock()
+	w.admitMu.Lock()
+	defer w.admitMu.Unlock()
+	return w.Queue.Len() == 0 && len(w.taskTurns) == 0 && len(w.admitted) == 0
+}
+
+// TestWorkerConcurrentRace calls the worker's API while its executors, task
+// checks, stats collection and pushes to the manager run, for go test -race
+// to find unguarded state. Without Docker, tasks fail to start and their
+// containers cannot be inspected, which the worker records like any other
+// outcome.
+func TestWorkerConcurrentRace(t *testing.T) {
+	var pushes atomic.Int64
+	mgr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := StatusReport{}
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&report) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		pushes.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer mgr.Close()
+
+	w := New("worker-test", "memory")
+	w.Manager = strings.TrimPrefix(mgr.URL, "http://")
+	w.Address = "localhost:5556"
+	w.Labels = map[string]string{"zone": "a"}
+	w.Taints = []task.Taint{{Key: "team", Value: "ml", Effect: task.PreferNoSchedule}}
+	// tasks the worker started before, whose containers it checks
+	var idsMu sync.Mutex
+	var ids []uuid.UUID
+	for i := 0; i < 10; i++ {
+		tk := task.Task{ID: uuid.New(), Name: fmt.Sprintf("old-%d", i), State: task.Running, ContainerID: uuid.NewString()}
+		w.Db.Put(tk.ID.String(), &tk)
+		ids = append(ids, tk.ID)
+	}
+	stored := append([]uuid.UUID(nil), ids...)
+	a := &Api{Worker: w}
+	a.initRouter()
+
+	go w.RunTasks()
+	go w.PushUpdates()
+	go w.CollectStats()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	loop := func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				f()
+				time.Sleep(5 * time.Millisecond)
+			}
+		}()
+	}
+	loop(w.updateTasks)
+	loop(w.collectStats)
+
+	serve := func(method string, path string, body interface{}) *httptest.ResponseRecorder {
+		var data []byte
+		if body != nil {
+			data, _ = json.Marshal(body)
+		}
+		rec := httptest.NewRecorder()
+		a.Router.ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader(data)))
+		return rec
+	}
+	randomID := func(r *rand.Rand) uuid.UUID {
+		idsMu.Lock()
+		defer idsMu.Unlock()
+		return ids[r.Intn(len(ids))]
+	}
+	calls := []func(r *rand.Rand){
+		func(r *rand.Rand) {
+			te := task.TaskEvent{
+				ID:    uuid.New(),
+				State: task.Scheduled,
+				Task:  task.Task{ID: uuid.New(), Name: "web", State: task.Scheduled, Image: "nginx"},
+			}
+			if rec := serve(http.MethodPost, "/tasks", te); rec.Code != http.StatusCreated {
+				t.Errorf("POST /tasks = %d, want 201", rec.Code)
+				return
+			}
+			idsMu.Lock()
+			ids = append(ids, te.Task.ID)
+			idsMu.Unlock()
+		},
+		func(r *rand.Rand) {
+			rec := serve(http.MethodDelete, "/tasks/"+randomID(r).String(), nil)
+			if rec.Code != http.StatusNoContent {
+				t.Errorf("DELETE /tasks/{id} = %d, want 204", rec.Code)
+			}
+		},
+		func(r *rand.Rand) {
+			rec := serve(http.MethodGet, "/tasks", nil)
+			var tasks []task.Task
+			if err := json.NewDecoder(rec.Body).Decode(&tasks); rec.Code != http.StatusOK || err != nil {
+				t.Errorf("GET /tasks = %d, %v", rec.Code, err)
+			}
+		},
+		func(r *rand.Rand) {
+			if rec := serve(http.MethodGet, "/stats", nil); rec.Code != http.StatusOK {
+				t.Errorf("GET /stats = %d, want 200", rec.Code)
+			}
+		},
+		func(r *rand.Rand) {
+			// only tasks the worker has stored can be probed
+			id := stored[r.Intn(len(stored))]
+			p := task.Probe{Exec: &task.ExecProbe{Command: []string{"true"}}, TimeoutSeconds: 1}
+			if rec := serve(http.MethodPost, "/tasks/"+id.String()+"/probe", p); rec.Code != http.StatusOK {
+				t.Errorf("POST /tasks/{id}/probe = %d, want 200", rec.Code)
+			}
+		},
+	}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				calls[r.Intn(len(calls))](r)
+				time.Sleep(time.Millisecond)
+			}
+		}(int64(i))
+	}
+
+	time.Sleep(2 * time.Second)
+	close(done)
+	wg.Wait()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !drained(w) {
+		if time.Now().After(deadline) {
+			t.Fatal("worker still has work queued or under way")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, tk := range w.GetTasks() {
+		switch tk.State {
+		case task.Scheduled, task.Stopping:
+			t.Errorf("task %s left %v once the worker's work was done", tk.ID, tk.State)
+		}
+	}
+	if pushes.Load() == 0 {
+		t.Error("no task state changes were pushed to the manager")
+	}
+}
+
+// TestRestartDoesNotReportCompleted restarts a failed task in a new container.
+// Removing the old container is part of the restart, so the manager must not
+// be told the task completed. Without Docker the new container fails to start.
+func TestRestartDoesNotReportCompleted(t *testing.T) {
+	w := New("worker-test", "memory")
+	w.Manager = "localhost:5555"
+	failed := task.Task{ID: uuid.New(), Name: "web", State: task.Failed, ContainerID: "c1"}
+	w.Db.Put(failed.ID.String(), &failed)
+
+	restarted := failed
+	restarted.RestartCount = 1
+	restarted.SetState(task.Scheduled, task.ReasonRestarted, "restart 1")
+	w.runTask(restarted)
+
+	close(w.updates)
+	var states []task.State
+	for u := range w.updates {
+		states = append(states, u.State)
+		if u.State == task.Completed {
+			t.Errorf("restart reported the task Completed, updates %v", states)
+		}
+	}
+	if len(states) == 0 {
+		t.Error("restart reported no state changes")
+	}
+}